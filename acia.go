@@ -0,0 +1,179 @@
+package main
+
+// ACIA is a 6551 Asynchronous Communications Interface Adapter talking to
+// a SerialLink. Characters take as many cycles to arrive and to leave as
+// the programmed baud rate and frame format need at the CPU's nominal
+// frequency.
+type ACIA struct {
+	Link *SerialLink
+
+	rxData  byte
+	txData  byte
+	status  byte
+	command byte
+	control byte
+	irq     IRQLine
+
+	// pending while a character is being shifted out
+	txEvent *Event
+}
+
+const (
+	aciaData = iota
+	aciaStatus
+	aciaCommand
+	aciaControl
+)
+
+// status register bits
+const (
+	aciaParityError = 1 << iota
+	aciaFramingError
+	aciaOverrun
+	aciaRxFull
+	aciaTxEmpty
+	aciaNoCarrier
+	aciaNotReady
+	aciaInterrupt
+)
+
+// baud rates selected by the low nibble of the control register, 0 is the
+// 16x external clock, taken here as 115200
+var aciaBaudRates = [16]float64{
+	115200, 50, 75, 109.92, 134.58, 150, 300, 600,
+	1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200,
+}
+
+func NewACIA(link *SerialLink) *ACIA {
+	a := &ACIA{Link: link, irq: cpu.NewIRQLine()}
+	a.reset()
+	cpu.After(a.charCycles(), a.receive)
+	return a
+}
+
+// reset is a programmed reset, a write to the status register. It leaves
+// the control register and the upper command bits alone.
+func (a *ACIA) reset() {
+	a.command &= 0xE0
+	a.status = a.status&^aciaOverrun | aciaTxEmpty
+	a.updateIRQ()
+}
+
+func (a *ACIA) Read(address uint16) byte {
+	switch address & 0x03 {
+	case aciaData:
+		a.status &^= aciaRxFull | aciaOverrun | aciaFramingError | aciaParityError
+		return a.rxData
+	case aciaStatus:
+		status := a.status
+		a.status &^= aciaInterrupt
+		a.updateIRQ()
+		return status
+	case aciaCommand:
+		return a.command
+	default:
+		return a.control
+	}
+}
+
+func (a *ACIA) Write(address uint16, value byte) {
+	switch address & 0x03 {
+	case aciaData:
+		a.transmit(value)
+	case aciaStatus:
+		a.reset()
+	case aciaCommand:
+		a.command = value
+	default:
+		a.control = value
+	}
+}
+
+// charCycles is the time one frame takes on the line: start bit, data
+// bits, parity and stop bits.
+func (a *ACIA) charCycles() uint64 {
+	bits := 1 + 8 - int(a.control>>5&0x03) + 1
+	if a.command&0x20 != 0 {
+		bits++
+	}
+	if a.control&0x80 != 0 {
+		bits++
+	}
+
+	// at least a cycle, or receive would keep rescheduling itself for now
+	return max(1, uint64(cpu.Frequency*float64(bits)/aciaBaudRates[a.control&0x0F]))
+}
+
+func (a *ACIA) receiverEnabled() bool {
+	return a.command&0x01 != 0
+}
+
+// receive polls the link once per character time while the receiver is
+// enabled, as if the host's characters arrived back to back. While a
+// character is unread the link holds the next one back, as hardware
+// handshaking would, so fast input such as a paste is not lost to
+// overruns.
+func (a *ACIA) receive(cycle uint64) {
+	cpu.Scheduler.At(cycle+a.charCycles(), a.receive)
+	if !a.receiverEnabled() || a.status&aciaRxFull != 0 {
+		return
+	}
+
+	b, ok := a.Link.Receive()
+	if !ok {
+		return
+	}
+
+	a.rxData = b & a.wordMask()
+	a.status |= aciaRxFull
+	if a.command&0x02 == 0 {
+		a.status |= aciaInterrupt
+		a.updateIRQ()
+	}
+
+	if a.command&0x10 != 0 && a.command&0x0C == 0 {
+		a.Link.Send(a.rxData)
+	}
+}
+
+// Close releases the link.
+func (a *ACIA) Close() error {
+	return a.Link.Close()
+}
+
+func (a *ACIA) wordMask() byte {
+	return 0xFF >> (a.control >> 5 & 0x03)
+}
+
+func (a *ACIA) transmit(value byte) {
+	a.txData = value
+	a.status &^= aciaTxEmpty
+	if a.txEvent == nil {
+		a.shiftOut(cpu.Cycles)
+	}
+}
+
+// shiftOut moves the transmit data register to the shift register, which
+// frees the register for the next character while this one goes out.
+func (a *ACIA) shiftOut(cycle uint64) {
+	a.txEvent = nil
+	if a.status&aciaTxEmpty != 0 {
+		return
+	}
+
+	value := a.txData & a.wordMask()
+	a.status |= aciaTxEmpty
+	if a.command&0x0C == 0x04 {
+		a.status |= aciaInterrupt
+		a.updateIRQ()
+	}
+
+	a.txEvent = cpu.Scheduler.At(cycle+a.charCycles(), func(cycle uint64) {
+		a.Link.Send(value)
+		a.shiftOut(cycle)
+	})
+}
+
+func (a *ACIA) updateIRQ() {
+	cpu.SetIRQ(a.irq, a.status&aciaInterrupt != 0)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestACIAHoldsInputWhileFull(t *testing.T) {
+	testCPU(t)
+	link := newSerialLink(io.Discard)
+	a := NewACIA(link)
+	a.Write(aciaControl, 0x1F) // 19200 baud, 8 bits
+	a.Write(aciaCommand, 0x0B) // receiver on, no IRQ
+	for _, b := range []byte("abc") {
+		link.in <- b
+	}
+
+	for _, want := range []byte("abc") {
+		// several character times pass before the program reads
+		cpu.Cycles += 10 * a.charCycles()
+		cpu.Scheduler.Run(cpu.Cycles)
+
+		status := a.Read(aciaStatus)
+		if status&aciaRxFull == 0 || status&aciaOverrun != 0 {
+			t.Fatalf("status $%02X before reading %q", status, want)
+		}
+		if got := a.Read(aciaData); got != want {
+			t.Fatalf("read %q, want %q", got, want)
+		}
+	}
+}
+
+func TestACIASlowClock(t *testing.T) {
+	c := testCPU(t)
+	c.Frequency = 1e4
+	a := NewACIA(newSerialLink(io.Discard))
+	a.Write(aciaControl, 0x10) // 115200 baud
+	if cycles := a.charCycles(); cycles != 1 {
+		t.Errorf("a character takes %d cycles, want 1", cycles)
+	}
+
+	// would not return if receive rescheduled itself for the same cycle
+	advance(c, 100)
+}
+
+func TestACIACloseReleasesLink(t *testing.T) {
+	testCPU(t)
+	link, err := OpenSerialLink("pty")
+	if err != nil {
+		t.Skip("no pseudo-terminal:", err)
+	}
+	master := link.out.(*os.File)
+
+	var bus Bus
+	if err := bus.Attach(0x6000, 4, NewACIA(link)); err != nil {
+		t.Fatal(err)
+	}
+	bus.Close()
+	if _, err := master.Write([]byte{'x'}); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("writing to the pty after Close: %v", err)
+	}
+	if err := link.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The Apple-1: RAM from $0000, the keyboard and display PIA at $D010 and
+// the ROM at the top of memory. A 256 byte image such as the Woz Monitor
+// sits at $FF00, larger ones up to 8K (BASIC with the monitor) end at
+// $FFFF as well.
+
+const (
+	apple1RAM     = 0x8000
+	apple1PIA     = 0xD010
+	apple1MaxROM  = 0x2000
+	apple1Columns = 40
+)
+
+// Apple1IO is the PIA wired up as on the board. Port A is the keyboard:
+// seven bits of ASCII with bit 7 tied high, the key strobe on CA1. Port B
+// bits 0-6 go to the display, which takes a character when CB2 (DA) drops
+// and answers on CB1 when done. PB7 reads the display busy, which it never
+// is here. The PIA's IRQ outputs are not connected.
+type Apple1IO struct {
+	*PIA
+	Out io.Writer
+
+	keys    chan byte
+	key     byte
+	column  int
+	restore func()
+}
+
+// how often the keyboard is looked at, in seconds of emulated time
+const apple1KeyPoll = 0.001
+
+func setupApple1(c *CPU) error {
+	if len(c.Rom) == 0 || len(c.Rom) > apple1MaxROM {
+		return fmt.Errorf("an Apple-1 ROM is 1 to %d bytes, not %d", apple1MaxROM, len(c.Rom))
+	}
+
+	pia, err := NewApple1IO()
+	if err != nil {
+		return err
+	}
+
+	c.Ram = make([]byte, apple1RAM)
+	c.Bus = Bus{}
+	c.Sandbox = false
+	return errors.Join(
+		c.Bus.Attach(0x0000, apple1RAM, ramWindow{}),
+		c.Bus.Attach(apple1PIA, 4, pia),
+		c.Bus.Attach(uint16(0x10000-len(c.Rom)), len(c.Rom), romWindow{}),
+	)
+}
+
+func NewApple1IO() (*Apple1IO, error) {
+	a := &Apple1IO{
+		PIA:     newPIA(0),
+		Out:     os.Stdout,
+		keys:    make(chan byte, 256),
+		restore: func() {},
+	}
+	a.InA = func() byte { return a.key | 0x80 }
+	a.InB = func() byte { return 0x00 }
+	a.CB2Out = a.dataAvailable
+
+	// piped input is taken as it comes, a terminal has to be switched to
+	// single keystrokes
+	if restore, err := rawInput(os.Stdin.Fd()); err == nil {
+		a.restore = restore
+	}
+
+	go a.readKeys()
+	cpu.After(a.pollCycles(), a.poll)
+	return a, nil
+}
+
+func (a *Apple1IO) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		for _, b := range buf[:n] {
+			if key, ok := apple1Key(b); ok {
+				a.keys <- key
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// apple1Key maps a host key to the keyboard's uppercase ASCII. Return is
+// CR, and backspace is the underscore the Woz Monitor uses to rub out.
+func apple1Key(b byte) (byte, bool) {
+	switch {
+	case b == '\n':
+		return '\r', true
+	case b == 0x08 || b == 0x7F:
+		return '_', true
+	case b >= 'a' && b <= 'z':
+		return b - 'a' + 'A', true
+	case b < 0x80:
+		return b, true
+	}
+	return 0, false
+}
+
+func (a *Apple1IO) pollCycles() uint64 {
+	return uint64(cpu.Frequency*apple1KeyPoll) + 1
+}
+
+// poll strobes the next key in once the program has read the last one.
+func (a *Apple1IO) poll(cycle uint64) {
+	cpu.Scheduler.At(cycle+a.pollCycles(), a.poll)
+	if a.ports[0].cr&piaIRQ1 != 0 {
+		return
+	}
+
+	select {
+	case a.key = <-a.keys:
+		a.SetCA1(true)
+		a.SetCA1(false)
+	default:
+	}
+}
+
+// dataAvailable is the display side of the CB2 strobe.
+func (a *Apple1IO) dataAvailable(level bool) {
+	if level {
+		return
+	}
+
+	port := &a.ports[1]
+	a.display((port.or&port.ddr | ^port.ddr) & 0x7F)
+	a.SetCB1(true)
+	a.SetCB1(false)
+}
+
+// display prints a character the way the Apple-1 terminal section does:
+// 40 columns, CR starts a new line and there is no lowercase or control
+// characters in the character generator.
+func (a *Apple1IO) display(char byte) {
+	switch {
+	case char == '\r':
+		a.column = 0
+		fmt.Fprint(a.Out, "\n")
+		return
+	case char < 0x20:
+		return
+	case char >= 0x60:
+		char -= 0x20
+	}
+
+	fmt.Fprintf(a.Out, "%c", rune(char))
+	a.column++
+	if a.column == apple1Columns {
+		a.column = 0
+		fmt.Fprint(a.Out, "\n")
+	}
+}
+
+func (a *Apple1IO) Close() error {
+	a.restore()
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// apple1CPU sets up an Apple-1 with a 256 byte ROM at $FF00 holding
+// program, and returns it with its PIA.
+func apple1CPU(t *testing.T, program ...byte) (*CPU, *Apple1IO) {
+	t.Helper()
+	saved := cpu
+	t.Cleanup(func() { cpu = saved })
+
+	cpu = &CPU{Frequency: 1e6}
+	cpu.Rom = make([]byte, 0x100)
+	copy(cpu.Rom, program)
+	cpu.Rom[0xFC], cpu.Rom[0xFD] = 0x00, 0xFF
+	if err := setupApple1(cpu); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cpu.Bus.Close)
+	cpu.Faults = FaultHalt
+	cpu.Reset()
+
+	return cpu, cpu.Bus.lookup(apple1PIA).device.(*Apple1IO)
+}
+
+func stepN(t *testing.T, c *CPU, n int) {
+	t.Helper()
+	for range n {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApple1CMPReadsMemory(t *testing.T) {
+	// LDA #$42, CMP $42 with $00 at $42
+	c, _ := apple1CPU(t, 0xA9, 0x42, 0xC5, 0x42)
+	stepN(t, c, 2)
+	if getFlag(FlagZ) != 0 || getFlag(FlagC) != 1 {
+		t.Errorf("CMP $42 compared with the operand, P=%s", flagString(c.P))
+	}
+}
+
+func TestSandboxCMPOperand(t *testing.T) {
+	// the same in the sandbox, which compares with the operand $42
+	c := testCPU(t, 0xA9, 0x42, 0xC5, 0x42)
+	stepN(t, c, 2)
+	if getFlag(FlagZ) != 1 {
+		t.Errorf("CMP $42 did not compare with the operand, P=%s", flagString(c.P))
+	}
+}
+
+func TestApple1Display(t *testing.T) {
+	c, pia := apple1CPU(t,
+		0xA0, 0x7F, // LDY #$7F
+		0x8C, 0x12, 0xD0, // STY DSP, the direction register
+		0xA9, 0xA7, // LDA #$A7
+		0x8D, 0x13, 0xD0, // STA DSPCR
+		0xA9, 0xC8, 0x8D, 0x12, 0xD0, // "H"
+		0xA9, 0xE9, 0x8D, 0x12, 0xD0, // "i", shown uppercase
+		0xA9, 0x8D, 0x8D, 0x12, 0xD0, // CR
+	)
+	var out bytes.Buffer
+	pia.Out = &out
+	stepN(t, c, 10)
+	if got := out.String(); got != "HI\n" {
+		t.Errorf("display shows %q, want %q", got, "HI\n")
+	}
+}
+
+func TestApple1Keyboard(t *testing.T) {
+	c, pia := apple1CPU(t)
+	c.Bus.Write(apple1PIA+1, 0xA7)
+	for _, b := range []byte("a\n") {
+		if key, ok := apple1Key(b); ok {
+			pia.keys <- key
+		}
+	}
+
+	for _, want := range []byte{'A' | 0x80, '\r' | 0x80} {
+		advance(c, pia.pollCycles())
+		if c.Bus.Read(apple1PIA+1)&piaIRQ1 == 0 {
+			t.Fatalf("no key strobe for %q", want&0x7F)
+		}
+		// the next key waits until this one is read
+		advance(c, pia.pollCycles())
+		if got := c.Bus.Read(apple1PIA); got != want {
+			t.Errorf("KBD = $%02X, want $%02X", got, want)
+		}
+		if c.Bus.Read(apple1PIA+1)&piaIRQ1 != 0 {
+			t.Error("reading KBD did not clear the strobe")
+		}
+	}
+}
@@ -9,6 +9,7 @@ type (
 		GetAddress  func() *uint16
 		Instruction func(*uint16)
 		Title       string
+		Access      int
 	}
 )
 
@@ -23,6 +24,14 @@ const (
 	FlagN
 )
 
+// how an instruction touches its operand, indexed modes pick their
+// dummy reads by it
+const (
+	AccessRead = iota
+	AccessWrite
+	AccessModify
+)
+
 func getFlag(flagNum uint8) uint8 {
 	return (cpu.P >> flagNum) & 0x01
 }
@@ -38,6 +47,7 @@ func setFlag(flagNum uint8, value uint8) {
 
 func accumulator() *uint16 {
 	cpu.PC++
+	cpu.dummyRead(cpu.PC)
 	return nil
 }
 
@@ -59,6 +69,7 @@ func zeroPageX() *uint16 {
 	cpu.PC++
 	address := uint16(cpu.Read(cpu.PC))
 	cpu.PC++
+	cpu.dummyRead(address)
 	address += uint16(cpu.X)
 	address &= 0xff
 	return &address
@@ -68,6 +79,7 @@ func zeroPageY() *uint16 {
 	cpu.PC++
 	address := uint16(cpu.Read(cpu.PC))
 	cpu.PC++
+	cpu.dummyRead(address)
 	address += uint16(cpu.Y)
 	address &= 0xff
 	return &address
@@ -88,16 +100,17 @@ func absolute() *uint16 {
 
 func absoluteX() *uint16 {
 	cpu.PC++
-	address := read16(cpu.PC)
+	base := read16(cpu.PC)
 	cpu.PC += 2
+	address := indexed(base, cpu.X)
 	return &address
 }
 
 func absoluteY() *uint16 {
 	cpu.PC++
-	address := read16(cpu.PC)
+	base := read16(cpu.PC)
 	cpu.PC += 2
-	address += uint16(cpu.Y)
+	address := indexed(base, cpu.Y)
 	return &address
 }
 
@@ -113,8 +126,8 @@ func indirectX() *uint16 {
 	cpu.PC++
 	pointer := uint16(cpu.Read(cpu.PC))
 	cpu.PC++
-	address := read16bug(pointer)
-	address += uint16(cpu.X)
+	cpu.dummyRead(pointer)
+	address := read16bug((pointer + uint16(cpu.X)) & 0xff)
 	return &address
 }
 
@@ -122,16 +135,28 @@ func indirectY() *uint16 {
 	cpu.PC++
 	pointer := uint16(cpu.Read(cpu.PC))
 	cpu.PC++
-	address := read16bug(pointer)
-	address += uint16(cpu.Y)
+	address := indexed(read16bug(pointer), cpu.Y)
 	return &address
 }
 
 func implied() *uint16 {
 	cpu.PC++
+	cpu.dummyRead(cpu.PC)
 	return nil
 }
 
+// indexed adds an index register to a base address. The 6502 adds to the
+// low byte first and reads from that unfixed address while it carries into
+// the high byte: reads pay the extra cycle only when a page is crossed,
+// writes and read-modify-write always do.
+func indexed(base uint16, index byte) uint16 {
+	address := base + uint16(index)
+	if cpu.op.Access != AccessRead || address&0xFF00 != base&0xFF00 {
+		cpu.dummyRead(base&0xFF00 | address&0x00FF)
+	}
+	return address
+}
+
 func read16(address uint16) uint16 {
 	low := uint16(cpu.Read(address))
 	high := uint16(cpu.Read(address + 1))
@@ -146,6 +171,28 @@ func read16bug(address uint16) uint16 {
 	return uint16(high)<<8 | uint16(low)
 }
 
+// branch moves PC past the offset and jumps when taken. A taken branch
+// spends a cycle reading the next opcode, one more if it lands on another
+// page.
+func branch(address *uint16, taken bool) {
+	cpu.Coverage.branched(cpu.instructionPC, taken)
+	cpu.PC++
+	if !taken {
+		return
+	}
+
+	cpu.dummyRead(cpu.PC)
+	target := cpu.PC + *address
+	if *address >= 0x80 {
+		target -= 0x100
+	}
+
+	if target&0xFF00 != cpu.PC&0xFF00 {
+		cpu.dummyRead(cpu.PC&0xFF00 | target&0x00FF)
+	}
+	cpu.PC = target
+}
+
 func adc(address *uint16) {
 	a := cpu.A
 	b := cpu.Read(*address)
@@ -193,6 +240,7 @@ func asl(address *uint16) {
 		value = &cpu.A
 	} else {
 		v := cpu.Read(*address)
+		cpu.dummyWrite(*address, v)
 		value = &v
 	}
 
@@ -214,44 +262,15 @@ func asl(address *uint16) {
 }
 
 func bcc(address *uint16) {
-	if getFlag(FlagC) == 0 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagC) == 0)
 }
 
 func bcs(address *uint16) {
-	if getFlag(FlagC) == 1 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagC) == 1)
 }
 
 func beq(address *uint16) {
-	if getFlag(FlagZ) == 1 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagZ) == 1)
 }
 
 func bit(address *uint16) {
@@ -266,70 +285,23 @@ func bit(address *uint16) {
 }
 
 func bmi(address *uint16) {
-	if getFlag(FlagN) != 0 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagN) != 0)
 }
 
 func bne(address *uint16) {
-	if getFlag(FlagZ) == 0 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagZ) == 0)
 }
 
 func bpl(address *uint16) {
-	if getFlag(FlagN) == 0 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagN) == 0)
 }
 
 func bvc(address *uint16) {
-	if getFlag(FlagV) == 0 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagV) == 0)
 }
 
 func bvs(address *uint16) {
-	if getFlag(FlagV) == 1 {
-		cpu.PC++
-		cpu.PC += *address
-
-		if *address >= 0x80 {
-			cpu.PC -= 0x100
-		}
-		return
-	}
-	cpu.PC++
+	branch(address, getFlag(FlagV) == 1)
 }
 
 func clc(*uint16) {
@@ -349,7 +321,13 @@ func clv(*uint16) {
 }
 
 func cmp(address *uint16) {
-	value := uint8(*address)
+	var value byte
+	if cpu.Sandbox {
+		cpu.dummyRead(*address)
+		value = uint8(*address)
+	} else {
+		value = cpu.Read(*address)
+	}
 
 	if cpu.A >= value {
 		setFlag(FlagC, 1)
@@ -406,7 +384,9 @@ func cpy(address *uint16) {
 }
 
 func dec(address *uint16) {
-	value := cpu.Read(*address) - 1
+	value := cpu.Read(*address)
+	cpu.dummyWrite(*address, value)
+	value--
 	cpu.Write(*address, value)
 
 	if value == 0 {
@@ -452,7 +432,9 @@ func eor(address *uint16) {
 }
 
 func inc(address *uint16) {
-	value := cpu.Read(*address) + 1
+	value := cpu.Read(*address)
+	cpu.dummyWrite(*address, value)
+	value++
 	cpu.Write(*address, value)
 	if value == 0 {
 		setFlag(FlagZ, 1)
@@ -489,10 +471,15 @@ func jmp(address *uint16) {
 	cpu.PC = *address
 }
 
+// jsr fetches the high byte of its target only after pushing the return
+// address, so it is given the location of the low byte and reads both itself.
 func jsr(address *uint16) {
-	cpu.PC--
+	low := uint16(cpu.Read(*address))
+	cpu.dummyRead(0x100 | uint16(cpu.S))
 	pushStack16(cpu.PC)
-	cpu.PC = *address
+	high := uint16(cpu.Read(cpu.PC))
+	cpu.PC = high<<8 | low
+	cpu.called("JSR", cpu.instructionPC, cpu.PC, cpu.instructionPC+2)
 }
 
 func lda(address *uint16) {
@@ -535,6 +522,7 @@ func lsr(address *uint16) {
 		value = &cpu.A
 	} else {
 		v := cpu.Read(*address)
+		cpu.dummyWrite(*address, v)
 		value = &v
 	}
 
@@ -580,6 +568,7 @@ func php(*uint16) {
 }
 
 func pla(*uint16) {
+	cpu.dummyRead(0x100 | uint16(cpu.S))
 	cpu.A = pullStack()
 	if cpu.A == 0 {
 		setFlag(FlagZ, 1)
@@ -591,6 +580,7 @@ func pla(*uint16) {
 }
 
 func plp(*uint16) {
+	cpu.dummyRead(0x100 | uint16(cpu.S))
 	cpu.P = pullStack()
 	cpu.P &= 0xef
 	cpu.P |= 0x20
@@ -604,6 +594,7 @@ func rol(address *uint16) {
 		value = &cpu.A
 	} else {
 		v := cpu.Read(*address)
+		cpu.dummyWrite(*address, v)
 		value = &v
 	}
 
@@ -632,6 +623,7 @@ func ror(address *uint16) {
 		value = &cpu.A
 	} else {
 		v := cpu.Read(*address)
+		cpu.dummyWrite(*address, v)
 		value = &v
 	}
 
@@ -652,7 +644,11 @@ func ror(address *uint16) {
 }
 
 func rts(*uint16) {
+	cpu.dummyRead(0x100 | uint16(cpu.S))
+	s := cpu.S
 	cpu.PC = pullStack16()
+	cpu.returned(false, s, cpu.PC)
+	cpu.dummyRead(cpu.PC)
 	cpu.PC++
 }
 
@@ -766,25 +762,34 @@ func tya(*uint16) {
 }
 
 func brk(*uint16) {
+	// skip the padding byte implied() has already read
+	cpu.PC++
+	cpu.checkRunaway()
 	pushStack16(cpu.PC)
 	php(nil)
 	sei(nil)
 	cpu.PC = read16(0xFFFE)
+	cpu.called("BRK", cpu.instructionPC, cpu.PC, cpu.instructionPC+2)
 }
 
 func rti(*uint16) {
+	cpu.dummyRead(0x100 | uint16(cpu.S))
+	s := cpu.S
 	cpu.P = pullStack()
 	cpu.P &= 0xef
 	cpu.P |= 0x20
 	cpu.PC = pullStack16()
+	cpu.returned(true, s, cpu.PC)
 }
 
 func pushStack(value byte) {
+	cpu.checkPush()
 	cpu.Write(0x100|uint16(cpu.S), value)
 	cpu.S--
 }
 
 func pullStack() byte {
+	cpu.checkPull()
 	cpu.S++
 	return cpu.Read(0x100 | uint16(cpu.S))
 }
@@ -1068,7 +1073,7 @@ func setAsmOpcodes() {
 	opcodes[0xB1].Instruction = lda
 	opcodes[0xB1].Title = "LDA (indirectY)"
 
-	opcodes[0x20].GetAddress = absolute
+	opcodes[0x20].GetAddress = immediate
 	opcodes[0x20].Instruction = jsr //x
 	opcodes[0x20].Title = "JSR (absolute)"
 
@@ -1311,4 +1316,21 @@ func setAsmOpcodes() {
 	opcodes[0x71].GetAddress = indirectY
 	opcodes[0x71].Instruction = adc
 	opcodes[0x71].Title = "ADC (indirectY)"
+
+	setAccessKinds()
+}
+
+func setAccessKinds() {
+	for i := range opcodes {
+		if len(opcodes[i].Title) < 3 {
+			continue
+		}
+
+		switch opcodes[i].Title[:3] {
+		case "STA", "STX", "STY":
+			opcodes[i].Access = AccessWrite
+		case "ASL", "LSR", "ROL", "ROR", "INC", "DEC":
+			opcodes[i].Access = AccessModify
+		}
+	}
 }
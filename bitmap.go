@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"os"
+)
+
+// Bitmap is a headless pixel framebuffer. Nothing is displayed: frames are
+// exported to PNG files or collected into an animated GIF, on request or
+// at every vertical blank.
+//
+//	$0000 pixels, row by row, packed most significant bits first at
+//	      Depth bits per pixel, each an index into the palette
+//	regs  +0 status: bit 7 vblank happened, cleared by reading
+//	      +1 control: bit 0 IRQ on vblank, bit 1 save a PNG every vblank,
+//	         bit 2 add a GIF frame every vblank
+//	      +2 command: 1 save a PNG, 2 add a GIF frame
+//	      +3 palette index, +4 red, +5 green, +6 blue. Writing blue sets
+//	         the color and moves to the next index
+//
+// The registers start at the first 256 byte boundary after the pixels.
+type Bitmap struct {
+	Width   int
+	Height  int
+	Depth   int
+	Palette color.Palette
+	// Rate is the vblank rate in Hz of emulated time
+	Rate float64
+	// PNGPattern names the PNG files, given the frame number
+	PNGPattern string
+	// GIFPath is where the collected frames are written on Close
+	GIFPath string
+
+	regs    uint16
+	pixels  []byte
+	status  byte
+	control byte
+	index   byte
+	rgb     [3]byte
+	irq     IRQLine
+
+	saved  int
+	frames []*image.Paletted
+}
+
+const (
+	bitmapStatus = iota
+	bitmapControl
+	bitmapCommand
+	bitmapIndex
+	bitmapRed
+	bitmapGreen
+	bitmapBlue
+)
+
+// the 16 VGA text colors, the default palette up to 4 bits per pixel
+var vgaPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xAA, 0xFF},
+	color.RGBA{0x00, 0xAA, 0x00, 0xFF}, color.RGBA{0x00, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x00, 0x00, 0xFF}, color.RGBA{0xAA, 0x00, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x55, 0x00, 0xFF}, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0x55, 0x55, 0x55, 0xFF}, color.RGBA{0x55, 0x55, 0xFF, 0xFF},
+	color.RGBA{0x55, 0xFF, 0x55, 0xFF}, color.RGBA{0x55, 0xFF, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0x55, 0x55, 0xFF}, color.RGBA{0xFF, 0x55, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0xFF, 0x55, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+func NewBitmap(width, height, depth int, rate float64) (*Bitmap, error) {
+	switch depth {
+	case 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("bitmap depth must be 1, 2, 4 or 8 bits, not %d", depth)
+	}
+
+	size := (width*height*depth + 7) / 8
+	if size+0x100 > 0x10000 {
+		return nil, fmt.Errorf("a %dx%d bitmap at %d bits does not fit in memory", width, height, depth)
+	}
+
+	b := &Bitmap{
+		Width:      width,
+		Height:     height,
+		Depth:      depth,
+		Rate:       rate,
+		PNGPattern: "frame%04d.png",
+		regs:       uint16((size + 0xFF) &^ 0xFF),
+		pixels:     make([]byte, size),
+		irq:        cpu.NewIRQLine(),
+	}
+
+	if depth == 8 {
+		b.Palette = append(color.Palette{}, palette.Plan9...)
+	} else {
+		b.Palette = append(color.Palette{}, vgaPalette[:1<<depth]...)
+		if depth == 1 {
+			b.Palette[1] = vgaPalette[15]
+		}
+	}
+
+	cpu.After(b.frameCycles(), b.vblank)
+	return b, nil
+}
+
+// Size is the address space the bitmap takes on the bus.
+func (b *Bitmap) Size() int {
+	return int(b.regs) + 7
+}
+
+func (b *Bitmap) Read(address uint16) byte {
+	if address < b.regs {
+		if int(address) < len(b.pixels) {
+			return b.pixels[address]
+		}
+		return 0
+	}
+
+	switch address - b.regs {
+	case bitmapStatus:
+		status := b.status
+		b.status = 0
+		cpu.SetIRQ(b.irq, false)
+		return status
+	case bitmapControl:
+		return b.control
+	case bitmapIndex:
+		return b.index
+	}
+	return 0
+}
+
+func (b *Bitmap) Write(address uint16, value byte) {
+	if address < b.regs {
+		if int(address) < len(b.pixels) {
+			b.pixels[address] = value
+		}
+		return
+	}
+
+	switch address - b.regs {
+	case bitmapControl:
+		b.control = value
+		cpu.SetIRQ(b.irq, b.control&0x01 != 0 && b.status != 0)
+	case bitmapCommand:
+		b.command(value)
+	case bitmapIndex:
+		b.index = value
+	case bitmapRed, bitmapGreen:
+		b.rgb[address-b.regs-bitmapRed] = value
+	case bitmapBlue:
+		if int(b.index) < len(b.Palette) {
+			b.Palette[b.index] = color.RGBA{b.rgb[0], b.rgb[1], value, 0xFF}
+		}
+		b.index++
+	}
+}
+
+func (b *Bitmap) command(value byte) {
+	switch value {
+	case 1:
+		b.savePNG()
+	case 2:
+		b.AddFrame()
+	}
+}
+
+func (b *Bitmap) frameCycles() uint64 {
+	return uint64(cpu.Frequency/b.Rate) + 1
+}
+
+func (b *Bitmap) vblank(cycle uint64) {
+	cpu.Scheduler.At(cycle+b.frameCycles(), b.vblank)
+
+	b.status = 0x80
+	cpu.SetIRQ(b.irq, b.control&0x01 != 0)
+	if b.control&0x02 != 0 {
+		b.savePNG()
+	}
+	if b.control&0x04 != 0 {
+		b.AddFrame()
+	}
+}
+
+// Snapshot renders the current frame.
+func (b *Bitmap) Snapshot() *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, b.Width, b.Height), append(color.Palette{}, b.Palette...))
+	perByte := 8 / b.Depth
+	mask := byte(1<<b.Depth - 1)
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			i := y*b.Width + x
+			shift := (perByte - 1 - i%perByte) * b.Depth
+			img.Pix[y*img.Stride+x] = b.pixels[i/perByte] >> shift & mask
+		}
+	}
+	return img
+}
+
+func (b *Bitmap) SavePNG(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, b.Snapshot()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *Bitmap) savePNG() {
+	path := fmt.Sprintf(b.PNGPattern, b.saved)
+	b.saved++
+	if err := b.SavePNG(path); err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot save frame", err)
+	}
+}
+
+// AddFrame appends the current frame to the animation.
+func (b *Bitmap) AddFrame() {
+	b.frames = append(b.frames, b.Snapshot())
+}
+
+// WriteGIF writes the frames added so far as an animated GIF, each shown
+// for one vblank period.
+func (b *Bitmap) WriteGIF(path string) error {
+	delay := int(100/b.Rate + 0.5)
+	anim := &gif.GIF{Image: b.frames, Delay: make([]int, len(b.frames))}
+	for i := range anim.Delay {
+		anim.Delay[i] = delay
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close writes the animation if there is anything to write.
+func (b *Bitmap) Close() error {
+	if b.GIFPath == "" || len(b.frames) == 0 {
+		return nil
+	}
+
+	return b.WriteGIF(b.GIFPath)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBitmapSnapshot(t *testing.T) {
+	testCPU(t)
+	b, err := NewBitmap(4, 2, 2, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Write(0, 0x1B)
+	b.Write(1, 0xE4)
+
+	img := b.Snapshot()
+	want := []byte{0, 1, 2, 3, 3, 2, 1, 0}
+	for i, index := range want {
+		if got := img.ColorIndexAt(i%4, i/4); got != index {
+			t.Errorf("pixel %d,%d = %d, want %d", i%4, i/4, got, index)
+		}
+	}
+}
+
+func TestBitmapPalette(t *testing.T) {
+	testCPU(t)
+	b, err := NewBitmap(8, 8, 4, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs := b.regs
+	b.Write(regs+bitmapIndex, 2)
+	for i, value := range []byte{0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC} {
+		b.Write(regs+bitmapRed+uint16(i%3), value)
+	}
+
+	if b.Palette[2] != (color.RGBA{0x12, 0x34, 0x56, 0xFF}) || b.Palette[3] != (color.RGBA{0x78, 0x9A, 0xBC, 0xFF}) {
+		t.Errorf("palette %v %v", b.Palette[2], b.Palette[3])
+	}
+	if index := b.Read(regs + bitmapIndex); index != 4 {
+		t.Errorf("index = %d after two colors from 2, want 4", index)
+	}
+}
+
+func TestBitmapPNG(t *testing.T) {
+	testCPU(t)
+	b, err := NewBitmap(16, 4, 4, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range b.pixels {
+		b.Write(uint16(i), byte(i*0x11+0x01))
+	}
+
+	path := filepath.Join(t.TempDir(), "frame.png")
+	if err := b.SavePNG(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := b.Snapshot()
+	if img.Bounds() != snapshot.Bounds() {
+		t.Fatalf("PNG is %v, want %v", img.Bounds(), snapshot.Bounds())
+	}
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			got := color.RGBAModel.Convert(img.At(x, y))
+			want := color.RGBAModel.Convert(snapshot.At(x, y))
+			if got != want {
+				t.Errorf("pixel %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestBitmapVblank(t *testing.T) {
+	c := testCPU(t)
+	base := c.Cycles
+	b, err := NewBitmap(8, 8, 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Write(b.regs+bitmapControl, 0x01)
+
+	advance(c, base+b.frameCycles()-1-c.Cycles)
+	if c.irq != 0 {
+		t.Fatal("vblank before a frame went by")
+	}
+	advance(c, 1)
+	if c.irq == 0 {
+		t.Fatal("no vblank IRQ after a frame")
+	}
+	if status := b.Read(b.regs + bitmapStatus); status != 0x80 {
+		t.Errorf("status = $%02X, want $80", status)
+	}
+	if c.irq != 0 || b.Read(b.regs+bitmapStatus) != 0 {
+		t.Error("reading the status did not clear the vblank")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// BlockDevice is a disk of 512 byte sectors kept in a host image file.
+// Sectors move either by DMA, straight to and from memory, or through a
+// one sector buffer read and written a byte at a time on the data port:
+//
+//	$00-$03 LBA, the first sector
+//	$04-$05 DMA address
+//	$06     DMA sector count, 0 for 256
+//	$07     write: command, see blockRead and the rest
+//	$08     status: bit 0 error, bit 6 write protected, bit 7 ready
+//	$09     error of the last command, see blockOK and the rest
+//	$0A     data port, stepping through the sector buffer
+//	$0B-$0E disk size in sectors
+//
+// Commands finish at once. A write protected disk refuses writes. With a
+// copy-on-write overlay the image is never written: changed sectors are
+// kept in memory, or in an overlay file that later runs start from.
+type BlockDevice struct {
+	image     *os.File
+	sectors   uint32
+	protected bool
+
+	overlay     map[uint32][]byte
+	overlayFile *os.File
+
+	regs   [7]byte
+	err    byte
+	buffer [blockSize]byte
+	index  int
+}
+
+const blockSize = 512
+
+const (
+	blockLBA = iota
+	_
+	_
+	_
+	blockDMA
+	_
+	blockCount
+	blockCommand
+	blockStatus
+	blockError
+	blockData
+	blockSectors
+)
+
+const (
+	blockRead = iota + 1
+	blockWrite
+	blockLoad
+	blockStore
+	blockFlush
+)
+
+const (
+	blockOK = iota
+	blockOutOfRange
+	blockProtected
+	blockIOError
+	blockBadCommand
+)
+
+// OpenBlockDevice opens a disk image. overlay is "" to write to the image
+// itself, "-" for a copy-on-write overlay in memory, or an overlay file.
+func OpenBlockDevice(path string, protected bool, overlay string) (*BlockDevice, error) {
+	flag := os.O_RDWR
+	if protected || overlay != "" {
+		flag = os.O_RDONLY
+	}
+
+	image, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := image.Stat()
+	if err != nil {
+		image.Close()
+		return nil, err
+	}
+
+	b := &BlockDevice{
+		image:     image,
+		sectors:   uint32((info.Size() + blockSize - 1) / blockSize),
+		protected: protected,
+	}
+
+	if overlay != "" {
+		b.overlay = make(map[uint32][]byte)
+	}
+	if overlay != "" && overlay != "-" {
+		if err := b.openOverlay(overlay); err != nil {
+			image.Close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// An overlay file is a list of records, a little endian LBA and the
+// sector, the last record for a sector winning. A run stopped in the
+// middle of writing a record leaves part of it at the end: that write
+// never finished, so the part is cut off and the file goes on from the
+// last whole record.
+func (b *BlockDevice) openOverlay(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, 4+blockSize)
+	var size int64
+	for {
+		_, err := io.ReadFull(f, record)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = f.Truncate(size)
+			if err == nil {
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		b.overlay[binary.LittleEndian.Uint32(record)] = append([]byte(nil), record[4:]...)
+		size += int64(len(record))
+	}
+
+	b.overlayFile = f
+	return nil
+}
+
+func (b *BlockDevice) Read(address uint16) byte {
+	switch {
+	case address < blockCommand:
+		return b.regs[address]
+	case address == blockStatus:
+		status := byte(0x80)
+		if b.err != blockOK {
+			status |= 0x01
+		}
+		if b.protected {
+			status |= 0x40
+		}
+		return status
+	case address == blockError:
+		return b.err
+	case address == blockData:
+		value := b.buffer[b.index]
+		b.index = (b.index + 1) % blockSize
+		return value
+	case address >= blockSectors && address < blockSectors+4:
+		return byte(b.sectors >> (8 * (address - blockSectors)))
+	}
+	return 0
+}
+
+func (b *BlockDevice) Write(address uint16, value byte) {
+	switch {
+	case address < blockCommand:
+		b.regs[address] = value
+	case address == blockCommand:
+		b.err = b.command(value)
+		b.index = 0
+	case address == blockData:
+		b.buffer[b.index] = value
+		b.index = (b.index + 1) % blockSize
+	}
+}
+
+func (b *BlockDevice) lba() uint32 {
+	return binary.LittleEndian.Uint32(b.regs[blockLBA:])
+}
+
+func (b *BlockDevice) dma() uint16 {
+	return binary.LittleEndian.Uint16(b.regs[blockDMA:])
+}
+
+func (b *BlockDevice) count() uint32 {
+	if b.regs[blockCount] == 0 {
+		return 256
+	}
+	return uint32(b.regs[blockCount])
+}
+
+func (b *BlockDevice) command(command byte) byte {
+	lba := b.lba()
+	count := uint32(1)
+	if command == blockRead || command == blockWrite {
+		count = b.count()
+	}
+
+	switch command {
+	case blockRead, blockWrite, blockLoad, blockStore:
+		if lba >= b.sectors || count > b.sectors-lba {
+			return blockOutOfRange
+		}
+	case blockFlush:
+		return b.flush()
+	default:
+		return blockBadCommand
+	}
+
+	if (command == blockWrite || command == blockStore) && b.protected {
+		return blockProtected
+	}
+
+	switch command {
+	case blockLoad:
+		return b.readSector(lba, b.buffer[:])
+	case blockStore:
+		return b.writeSector(lba, b.buffer[:])
+	}
+
+	address := b.dma()
+	sector := make([]byte, blockSize)
+	for i := uint32(0); i < count; i++ {
+		if command == blockRead {
+			if err := b.readSector(lba+i, sector); err != blockOK {
+				return err
+			}
+			for _, value := range sector {
+				cpu.Bus.Write(address, value)
+				address++
+			}
+		} else {
+			for j := range sector {
+				sector[j] = cpu.Bus.Read(address)
+				address++
+			}
+			if err := b.writeSector(lba+i, sector); err != blockOK {
+				return err
+			}
+		}
+	}
+	return blockOK
+}
+
+func (b *BlockDevice) readSector(lba uint32, sector []byte) byte {
+	if data, ok := b.overlay[lba]; ok {
+		copy(sector, data)
+		return blockOK
+	}
+
+	// the last sector of an image that is not a whole number of them
+	// reads as padded with zeros
+	clear(sector)
+	if _, err := b.image.ReadAt(sector, int64(lba)*blockSize); err != nil && !errors.Is(err, io.EOF) {
+		return blockIOError
+	}
+	return blockOK
+}
+
+func (b *BlockDevice) writeSector(lba uint32, sector []byte) byte {
+	if b.overlay == nil {
+		if _, err := b.image.WriteAt(sector, int64(lba)*blockSize); err != nil {
+			return blockIOError
+		}
+		return blockOK
+	}
+
+	b.overlay[lba] = append([]byte(nil), sector...)
+	if b.overlayFile != nil {
+		record := binary.LittleEndian.AppendUint32(nil, lba)
+		if _, err := b.overlayFile.Write(append(record, sector...)); err != nil {
+			return blockIOError
+		}
+	}
+	return blockOK
+}
+
+func (b *BlockDevice) flush() byte {
+	var f *os.File
+	switch {
+	case b.overlayFile != nil:
+		f = b.overlayFile
+	case b.overlay != nil || b.protected:
+		return blockOK
+	default:
+		f = b.image
+	}
+
+	if err := f.Sync(); err != nil {
+		return blockIOError
+	}
+	return blockOK
+}
+
+func (b *BlockDevice) Close() error {
+	if b.overlayFile != nil {
+		b.overlayFile.Close()
+	}
+	return b.image.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage writes a disk image of sectors sectors, each filled with its
+// number, and returns its path.
+func testImage(t *testing.T, sectors int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.img")
+	var image []byte
+	for i := range sectors {
+		image = append(image, bytes.Repeat([]byte{byte(i)}, blockSize)...)
+	}
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func blockCommandAt(b *BlockDevice, command byte, lba byte) byte {
+	b.Write(blockLBA, lba)
+	b.Write(blockCommand, command)
+	return b.Read(blockError)
+}
+
+// storeSector writes a sector of value through the data port.
+func storeSector(t *testing.T, b *BlockDevice, lba, value byte) {
+	t.Helper()
+	for range blockSize {
+		b.Write(blockData, value)
+	}
+	if err := blockCommandAt(b, blockStore, lba); err != blockOK {
+		t.Fatalf("store to sector %d failed with %d", lba, err)
+	}
+}
+
+// loadSector reads a sector through the data port.
+func loadSector(t *testing.T, b *BlockDevice, lba byte) []byte {
+	t.Helper()
+	if err := blockCommandAt(b, blockLoad, lba); err != blockOK {
+		t.Fatalf("load of sector %d failed with %d", lba, err)
+	}
+	sector := make([]byte, blockSize)
+	for i := range sector {
+		sector[i] = b.Read(blockData)
+	}
+	return sector
+}
+
+func TestBlockDeviceDMA(t *testing.T) {
+	c := testCPU(t)
+	b, err := OpenBlockDevice(testImage(t, 4), false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// sectors 1 and 2 to $0400
+	b.Write(blockDMA+1, 0x04)
+	b.Write(blockCount, 2)
+	if err := blockCommandAt(b, blockRead, 1); err != blockOK {
+		t.Fatalf("read failed with %d", err)
+	}
+	if c.Ram[0x0400] != 1 || c.Ram[0x05FF] != 1 || c.Ram[0x0600] != 2 || c.Ram[0x07FF] != 2 {
+		t.Error("DMA read the wrong sectors")
+	}
+
+	// and back over sectors 2 and 3
+	if err := blockCommandAt(b, blockWrite, 2); err != blockOK {
+		t.Fatalf("write failed with %d", err)
+	}
+	if got := loadSector(t, b, 3); got[0] != 2 {
+		t.Errorf("sector 3 holds %d after the write, want 2", got[0])
+	}
+
+	if err := blockCommandAt(b, blockRead, 3); err != blockOutOfRange {
+		t.Errorf("reading past the end returned %d", err)
+	}
+}
+
+func TestBlockDeviceProtected(t *testing.T) {
+	testCPU(t)
+	b, err := OpenBlockDevice(testImage(t, 2), true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := blockCommandAt(b, blockStore, 0); err != blockProtected {
+		t.Errorf("store to a protected disk returned %d", err)
+	}
+	if b.Read(blockStatus) != 0xC1 {
+		t.Errorf("status $%02X, want ready, protected and error", b.Read(blockStatus))
+	}
+}
+
+func TestBlockDeviceOverlay(t *testing.T) {
+	testCPU(t)
+	image := testImage(t, 4)
+	overlay := filepath.Join(t.TempDir(), "disk.ovl")
+
+	b, err := OpenBlockDevice(image, false, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeSector(t, b, 1, 0xAA)
+	storeSector(t, b, 2, 0xBB)
+	storeSector(t, b, 1, 0xCC)
+	if got := loadSector(t, b, 1); got[0] != 0xCC {
+		t.Errorf("sector 1 holds $%02X, want the last write $CC", got[0])
+	}
+	b.Close()
+
+	if data, _ := os.ReadFile(image); data[blockSize] != 1 {
+		t.Error("the image was written")
+	}
+
+	// a later run starts from the overlay
+	b, err = OpenBlockDevice(image, false, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lba, want := range []byte{0, 0xCC, 0xBB, 3} {
+		if got := loadSector(t, b, byte(lba)); got[0] != want || got[blockSize-1] != want {
+			t.Errorf("sector %d holds $%02X, want $%02X", lba, got[0], want)
+		}
+	}
+	b.Close()
+}
+
+func TestBlockDeviceTruncatedOverlay(t *testing.T) {
+	testCPU(t)
+	image := testImage(t, 4)
+	overlay := filepath.Join(t.TempDir(), "disk.ovl")
+
+	b, err := OpenBlockDevice(image, false, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeSector(t, b, 1, 0xAA)
+	b.Close()
+
+	// a run stopped half way through writing sector 2
+	f, err := os.OpenFile(overlay, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{2, 0, 0, 0, 0xBB, 0xBB})
+	f.Close()
+
+	b, err = OpenBlockDevice(image, false, overlay)
+	if err != nil {
+		t.Fatalf("overlay with a partial record: %v", err)
+	}
+	if got := loadSector(t, b, 2); got[0] != 2 {
+		t.Errorf("sector 2 holds $%02X from the partial record", got[0])
+	}
+	storeSector(t, b, 3, 0xDD)
+	b.Close()
+
+	b, err = OpenBlockDevice(image, false, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for lba, want := range []byte{0, 0xAA, 2, 0xDD} {
+		if got := loadSector(t, b, byte(lba)); got[0] != want {
+			t.Errorf("sector %d holds $%02X, want $%02X", lba, got[0], want)
+		}
+	}
+}
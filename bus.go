@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Every 6502 cycle is a bus cycle: when an instruction is busy internally
+// it still reads (or, in read-modify-write instructions, writes) some
+// address and ignores the result. Devices with side effects on access can
+// tell the difference, so BusAccurate mode performs these accesses for
+// real. Otherwise they only advance the cycle counter.
+
+func (c *CPU) dummyRead(address uint16) {
+	if c.BusAccurate {
+		c.dummy = true
+		c.Read(address)
+		c.dummy = false
+		return
+	}
+
+	c.Cycles++
+}
+
+// dummyWrite is the write of the unmodified value that read-modify-write
+// instructions do before writing the result.
+func (c *CPU) dummyWrite(address uint16, value byte) {
+	if c.BusAccurate {
+		c.dummy = true
+		c.Write(address, value)
+		c.dummy = false
+		return
+	}
+
+	c.Cycles++
+}
+
+// Device is a peripheral on the bus. It gets addresses as offsets from
+// where it is attached.
+type Device interface {
+	Read(address uint16) byte
+	Write(address uint16, value byte)
+}
+
+// readOnly devices are ROM: the CPU's writes to them are faults and do
+// not reach them.
+type readOnly interface {
+	readOnly()
+}
+
+// memory devices are RAM and ROM, where code can run. The rest are I/O
+// registers and screens.
+type memory interface {
+	memory()
+}
+
+type mapping struct {
+	start  uint16
+	end    uint16
+	device Device
+}
+
+// Bus decodes addresses to the devices attached there. Where ranges
+// overlap the device attached last wins, so devices can be laid over
+// the machine's memory. Where nothing is attached the data bus floats:
+// on NMOS parts a read returns the last value it carried, often the high
+// byte of the address just fetched.
+type Bus struct {
+	pages   [256][]*mapping
+	devices []Device
+	// data is the last value on the data bus. Without BusAccurate the
+	// dummy accesses are not on the bus and do not change it.
+	data byte
+	// closing is held by Close, which a second Ctrl-C may call while the
+	// main goroutine is in it
+	closing sync.Mutex
+}
+
+// Attach maps device over size bytes from start, which must end by $FFFF.
+func (b *Bus) Attach(start uint16, size int, device Device) error {
+	if size <= 0 || int(start)+size > 0x10000 {
+		return fmt.Errorf("cannot attach %d bytes at $%04X, the address space ends at $FFFF", size, start)
+	}
+
+	end := uint16(int(start) + size - 1)
+	m := &mapping{start: start, end: end, device: device}
+	b.devices = append(b.devices, device)
+	for page := int(start >> 8); page <= int(end>>8); page++ {
+		b.pages[page] = append([]*mapping{m}, b.pages[page]...)
+	}
+	return nil
+}
+
+func (b *Bus) lookup(address uint16) *mapping {
+	for _, m := range b.pages[address>>8] {
+		if address >= m.start && address <= m.end {
+			return m
+		}
+	}
+	return nil
+}
+
+// Read and Write are accesses by devices, such as DMA, which need not
+// follow the rules for the CPU's, and by the emulator looking at memory.
+// They leave the data bus alone.
+func (b *Bus) Read(address uint16) byte {
+	if m := b.lookup(address); m != nil {
+		return m.device.Read(address - m.start)
+	}
+	return b.data
+}
+
+func (b *Bus) Write(address uint16, value byte) {
+	if m := b.lookup(address); m != nil {
+		m.device.Write(address-m.start, value)
+	}
+}
+
+// read reports whether anything is attached at address. Where nothing
+// is, the value is whatever was last on the data bus.
+func (b *Bus) read(address uint16) (byte, bool) {
+	m := b.lookup(address)
+	if m == nil {
+		return b.data, false
+	}
+
+	b.data = m.device.Read(address - m.start)
+	return b.data, true
+}
+
+// write returns the kind of fault if the write is not allowed.
+func (b *Bus) write(address uint16, value byte) string {
+	b.data = value
+	m := b.lookup(address)
+	if m == nil {
+		return faultUnmapped
+	}
+	if _, ok := m.device.(readOnly); ok {
+		return faultROM
+	}
+
+	m.device.Write(address-m.start, value)
+	return ""
+}
+
+// Close closes every attached device that holds host resources, such as
+// a terminal to restore or files to finish. Closing twice does nothing,
+// and a second call waits for the first to finish.
+func (b *Bus) Close() {
+	b.closing.Lock()
+	defer b.closing.Unlock()
+
+	closed := make(map[Device]bool)
+	for _, device := range b.devices {
+		if closer, ok := device.(io.Closer); ok && !closed[device] {
+			closed[device] = true
+			_ = closer.Close()
+		}
+	}
+	b.devices = nil
+}
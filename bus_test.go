@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBusAttachRange(t *testing.T) {
+	var bus Bus
+	if err := bus.Attach(0xFF00, 0x100, make(memoryBlock, 0x100)); err != nil {
+		t.Errorf("attaching up to $FFFF: %v", err)
+	}
+
+	for _, r := range []struct {
+		start uint16
+		size  int
+	}{{0xFFF8, 16}, {0x0000, 0x10001}, {0x8000, 0}, {0x8000, -1}} {
+		if err := bus.Attach(r.start, r.size, make(memoryBlock, 16)); err == nil {
+			t.Errorf("attaching %d bytes at $%04X succeeded", r.size, r.start)
+		}
+	}
+	if bus.lookup(0x0000) != nil {
+		t.Error("a failed attach wrapped around to $0000")
+	}
+}
+
+func TestBusOverlap(t *testing.T) {
+	var bus Bus
+	low, high := make(memoryBlock, 0x100), make(memoryBlock, 0x10)
+	for _, err := range []error{bus.Attach(0x1000, 0x100, low), bus.Attach(0x1010, 0x10, high)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bus.Write(0x1015, 0x42)
+	bus.Write(0x1020, 0x43)
+	if high[5] != 0x42 || low[0x15] != 0 {
+		t.Error("a write under the device attached last reached the one below")
+	}
+	if low[0x20] != 0x43 {
+		t.Error("a write past the device attached last did not reach the one below")
+	}
+}
+
+func TestBusReadOnly(t *testing.T) {
+	// LDA #$42, STA $9000, NOP
+	program := []byte{0xA9, 0x42, 0x8D, 0x00, 0x90, 0xEA}
+
+	c := testCPU(t, program...)
+	err := c.Run(func() bool { return false })
+	var fault *MemoryFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("Run returned %v, want a *MemoryFault", err)
+	}
+	if fault.Kind != faultROM || !fault.Write || fault.Address != 0x9000 || fault.Value != 0x42 || fault.PC != 0x8002 {
+		t.Errorf("fault %+v", fault)
+	}
+	if c.Rom[0x1000] != 0 {
+		t.Error("the write reached ROM")
+	}
+
+	c = testCPU(t, program...)
+	c.Faults = FaultIgnore
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Errorf("Run ignoring faults returned %v, want ErrHalted", err)
+	}
+}
+
+// unmappedCPU is testCPU with nothing attached from $2000 to $7FFF.
+func unmappedCPU(t *testing.T, program ...byte) *CPU {
+	c := testCPU(t, program...)
+	c.Bus = Bus{}
+	if err := errors.Join(
+		c.Bus.Attach(0x0000, 0x2000, ramWindow{}),
+		c.Bus.Attach(0x8000, 0x8000, romWindow{}),
+	); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBusUnmapped(t *testing.T) {
+	// LDA $4000, NOP
+	program := []byte{0xAD, 0x00, 0x40, 0xEA}
+
+	c := unmappedCPU(t, program...)
+	err := c.Run(func() bool { return false })
+	var fault *MemoryFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("Run returned %v, want a *MemoryFault", err)
+	}
+	if fault.Kind != faultUnmapped || fault.Write || fault.Address != 0x4000 || fault.PC != 0x8000 {
+		t.Errorf("fault %+v", fault)
+	}
+
+	// the read gets the high byte of the address, the last value fetched
+	c = unmappedCPU(t, program...)
+	c.Faults = FaultIgnore
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Fatalf("Run ignoring faults returned %v, want ErrHalted", err)
+	}
+	if c.A != 0x40 {
+		t.Errorf("A = $%02X, want the open bus value $40", c.A)
+	}
+
+	// looking at memory leaves the data bus alone
+	c.Bus.data = 0x55
+	c.Bus.Read(0x8000)
+	if got := c.Bus.Read(0x4000); got != 0x55 {
+		t.Errorf("Bus.Read of unmapped memory = $%02X, want $55", got)
+	}
+}
+
+// busRecorder lies over the whole bus and records the accesses that pass
+// through it to the bus below.
+type busRecorder struct {
+	bus      *Bus
+	accesses []busAccess
+}
+
+type busAccess struct {
+	write   bool
+	address uint16
+	value   byte
+}
+
+func (r *busRecorder) Read(address uint16) byte {
+	value := r.bus.Read(address)
+	r.accesses = append(r.accesses, busAccess{false, address, value})
+	return value
+}
+
+func (r *busRecorder) Write(address uint16, value byte) {
+	r.accesses = append(r.accesses, busAccess{true, address, value})
+	r.bus.Write(address, value)
+}
+
+func TestBusAccurateCycles(t *testing.T) {
+	// LDX #$01, INC $0300,X, LDA $02FF,X, BNE +0, NOP
+	c := testCPU(t, 0xA2, 0x01, 0xFE, 0x00, 0x03, 0xBD, 0xFF, 0x02, 0xD0, 0x00, 0xEA)
+	c.BusAccurate = true
+	c.Ram[0x0300], c.Ram[0x0301] = 0x07, 0x41
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &busRecorder{bus: &Bus{pages: c.Bus.pages}}
+	c.Bus = Bus{}
+	if err := c.Bus.Attach(0x0000, 0x10000, recorder); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want []busAccess
+	}{
+		{"RMW absolute,X", []busAccess{
+			{false, 0x8002, 0xFE}, {false, 0x8003, 0x00}, {false, 0x8004, 0x03},
+			// the unfixed address, read even without a page crossing
+			{false, 0x0301, 0x41},
+			{false, 0x0301, 0x41}, {true, 0x0301, 0x41}, {true, 0x0301, 0x42},
+		}},
+		{"page-crossing absolute,X read", []busAccess{
+			{false, 0x8005, 0xBD}, {false, 0x8006, 0xFF}, {false, 0x8007, 0x02},
+			{false, 0x0200, 0x00},
+			{false, 0x0300, 0x07},
+		}},
+		{"taken branch", []busAccess{
+			{false, 0x8008, 0xD0}, {false, 0x8009, 0x00},
+			{false, 0x800A, 0xEA},
+		}},
+	} {
+		recorder.accesses = nil
+		start := c.Cycles
+		if err := c.Step(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(recorder.accesses, tt.want) {
+			t.Errorf("%s: bus cycles %+v, want %+v", tt.name, recorder.accesses, tt.want)
+		}
+		if cycles := c.Cycles - start; cycles != uint64(len(tt.want)) {
+			t.Errorf("%s took %d cycles for %d bus cycles", tt.name, cycles, len(tt.want))
+		}
+	}
+}
+
+// closeCounter is a device that counts how often it is closed.
+type closeCounter struct {
+	memoryBlock
+	closes atomic.Int32
+}
+
+func (c *closeCounter) Close() error {
+	c.closes.Add(1)
+	return nil
+}
+
+func TestBusCloseOnce(t *testing.T) {
+	var bus Bus
+	device := &closeCounter{memoryBlock: make(memoryBlock, 1)}
+	if err := bus.Attach(0x6000, 1, device); err != nil {
+		t.Fatal(err)
+	}
+
+	// the main goroutine and a second Ctrl-C
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(bus.Close)
+	}
+	wg.Wait()
+	if n := device.closes.Load(); n != 1 {
+		t.Errorf("device closed %d times", n)
+	}
+}
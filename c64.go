@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The Commodore 64 without its ROMs or chips: 64K of RAM holding a PRG
+// file, and KERNAL calls serviced in Go by traps on the jump table. There
+// is no VIC-II, so programs talk to the console through CHROUT, CHRIN
+// and GETIN, and their disk files are host files in the current
+// directory, with lowercase names.
+//
+// A PRG starts with its load address. One loaded at $0801 is a BASIC
+// program and is started at the address of its SYS line, anything else
+// at the load address. Returning from it ends the run.
+
+const (
+	c64Kernal = 0xE000
+	// where the boot code calls the program, the KERNAL's reset address
+	c64Reset = 0xFCE2
+	c64Exit  = c64Reset + 3
+	// ST, the I/O status byte READST returns
+	c64Status = 0x90
+)
+
+// KERNAL error codes, returned in A with carry set
+const (
+	c64FileOpen = iota + 2
+	c64FileNotOpen
+	c64FileNotFound
+	c64DeviceNotPresent
+	c64NotInputFile
+	c64NotOutputFile
+	c64MissingFileName
+)
+
+// C64Kernal is the KERNAL ROM at $E000. It reads as RTS on the jump table
+// entries, where the traps are, with enough code around them to boot the
+// program and pass interrupts on through the $0314 vector. As on the real
+// machine, writes go to the RAM underneath and clearing HIRAM in $01
+// shows that RAM instead.
+type C64Kernal struct {
+	Out  io.Writer
+	Root *os.Root
+
+	rom     [0x2000]byte
+	keys    chan byte
+	echo    bool
+	line    []byte
+	lower   bool
+	row     int
+	column  int
+	status  byte
+	file    byte
+	device  byte
+	second  byte
+	name    string
+	files   map[byte]*c64File
+	input   *c64File
+	output  *c64File
+	restore func()
+}
+
+// c64File is a logical file from OPEN. Keyboard and screen files have no
+// host file.
+type c64File struct {
+	device byte
+	f      *os.File
+	r      *bufio.Reader
+	write  bool
+}
+
+func setupC64(c *CPU) error {
+	if len(c.Rom) < 3 {
+		return errors.New("a PRG file is a load address and the program")
+	}
+
+	load := int(c.Rom[0]) | int(c.Rom[1])<<8
+	if load+len(c.Rom)-2 > c64Kernal {
+		return fmt.Errorf("a %d byte program at $%04X runs into the KERNAL", len(c.Rom)-2, load)
+	}
+
+	kernal, err := NewC64Kernal(c64Start(c.Rom, uint16(load)))
+	if err != nil {
+		return err
+	}
+
+	c.Ram = make([]byte, 0x10000)
+	copy(c.Ram[load:], c.Rom[2:])
+	// the processor port with all ROMs in, and the IRQ vector
+	c.Ram[0x01] = 0x37
+	c.Ram[0x0314], c.Ram[0x0315] = 0x31, 0xEA
+
+	c.Bus = Bus{}
+	c.Sandbox = false
+	c.Traps = kernal.traps()
+	return errors.Join(
+		c.Bus.Attach(0x0000, 0x10000, ramWindow{}),
+		c.Bus.Attach(c64Kernal, 0x2000, kernal),
+	)
+}
+
+// c64Start reads the address from a BASIC loader line like 10 SYS 2061.
+func c64Start(prg []byte, load uint16) uint16 {
+	// link and line number, then the SYS token
+	if load != 0x0801 || len(prg) < 8 || prg[6] != 0x9E {
+		return load
+	}
+
+	var start int
+	digits := strings.TrimLeft(string(prg[7:]), " (")
+	if _, err := fmt.Sscanf(digits, "%d", &start); err != nil || start > 0xFFFF {
+		return load
+	}
+	return uint16(start)
+}
+
+func NewC64Kernal(start uint16) (*C64Kernal, error) {
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return nil, err
+	}
+
+	k := &C64Kernal{
+		Out:     os.Stdout,
+		Root:    root,
+		keys:    make(chan byte, 256),
+		files:   make(map[byte]*c64File),
+		restore: func() {},
+	}
+
+	for address := 0xFF81; address < 0xFFF6; address++ {
+		k.poke(uint16(address), 0x60)
+	}
+	// JSR to the program, then the exit trap
+	k.poke(c64Reset, 0x20, byte(start), byte(start>>8))
+	// IRQ: save the registers and go through $0314, which ends at $EA81
+	// restoring them. There is no keyboard to scan at $EA31
+	k.poke(0xFF48, 0x48, 0x8A, 0x48, 0x98, 0x48, 0x6C, 0x14, 0x03)
+	k.poke(0xEA31, 0x4C, 0x81, 0xEA)
+	k.poke(0xEA81, 0x68, 0xA8, 0x68, 0xAA, 0x68, 0x40)
+	k.poke(0xFE43, 0x40)
+	k.poke(0xFFFA, 0x43, 0xFE, c64Reset&0xFF, c64Reset>>8, 0x48, 0xFF)
+
+	if restore, err := rawInput(os.Stdin.Fd()); err == nil {
+		k.restore = restore
+		k.echo = true
+	}
+	go k.readKeys()
+	return k, nil
+}
+
+func (k *C64Kernal) poke(address uint16, values ...byte) {
+	copy(k.rom[address-c64Kernal:], values)
+}
+
+func (k *C64Kernal) Read(address uint16) byte {
+	if cpu.Ram[0x01]&0x02 == 0 {
+		return cpu.Ram[c64Kernal+int(address)]
+	}
+	return k.rom[address]
+}
+
+func (k *C64Kernal) Write(address uint16, value byte) {
+	cpu.Ram[c64Kernal+int(address)] = value
+}
+
+func (*C64Kernal) memory() {}
+
+func (k *C64Kernal) traps() map[uint16]func() bool {
+	traps := map[uint16]func() bool{
+		0xFFB7: k.readst,
+		0xFFBA: k.setlfs,
+		0xFFBD: k.setnam,
+		0xFFC0: k.open,
+		0xFFC3: k.close,
+		0xFFC6: k.chkin,
+		0xFFC9: k.chkout,
+		0xFFCC: k.clrchn,
+		0xFFCF: k.chrin,
+		0xFFD2: k.chrout,
+		0xFFD5: k.load,
+		0xFFD8: k.save,
+		0xFFE4: k.getin,
+		0xFFF0: k.plot,
+		c64Exit: func() bool {
+			return true
+		},
+	}
+
+	// with the KERNAL banked out the program has its own code there
+	for address, trap := range traps {
+		traps[address] = func() bool {
+			return cpu.Ram[0x01]&0x02 != 0 && trap()
+		}
+	}
+	return traps
+}
+
+func (k *C64Kernal) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		for _, b := range buf[:n] {
+			k.keys <- b
+		}
+		if err != nil {
+			close(k.keys)
+			return
+		}
+	}
+}
+
+// Results
+
+func (k *C64Kernal) succeed() {
+	setFlag(FlagC, 0)
+}
+
+func (k *C64Kernal) fail(code byte) {
+	cpu.A = code
+	setFlag(FlagC, 1)
+}
+
+// setA returns a byte with the flags set as if loaded, GETIN callers
+// test it with BEQ straight away.
+func (k *C64Kernal) setA(value byte) {
+	cpu.A = value
+	if value == 0 {
+		setFlag(FlagZ, 1)
+	} else {
+		setFlag(FlagZ, 0)
+	}
+	setFlag(FlagN, value>>7)
+	k.succeed()
+}
+
+func (k *C64Kernal) setStatus(bits byte) {
+	k.status |= bits
+	cpu.Bus.Write(c64Status, k.status)
+}
+
+func (k *C64Kernal) clearStatus() {
+	k.status = 0
+	cpu.Bus.Write(c64Status, 0)
+}
+
+// Character set
+
+// ascii turns a PETSCII character into what the terminal shows for it.
+// Letters follow the character set $0E and $8E switch between, graphics
+// show as spaces.
+func (k *C64Kernal) ascii(b byte) byte {
+	switch {
+	case b >= 0x41 && b <= 0x5A:
+		if k.lower {
+			return b + 0x20
+		}
+		return b
+	case b >= 0x61 && b <= 0x7A:
+		return b - 0x20
+	case b >= 0xC1 && b <= 0xDA:
+		return b - 0x80
+	case b >= 0x20 && b < 0x60:
+		return b
+	}
+	return ' '
+}
+
+// petscii turns a host key into the C64 keyboard's character.
+func (k *C64Kernal) petscii(b byte) (byte, bool) {
+	switch {
+	case b == '\n' || b == '\r':
+		return 0x0D, true
+	case b == 0x08 || b == 0x7F:
+		return 0x14, true
+	case b >= 'a' && b <= 'z':
+		return b - 0x20, true
+	case b >= 'A' && b <= 'Z':
+		if k.lower {
+			return b + 0x80, true
+		}
+		return b, true
+	case b >= 0x20 && b < 0x60:
+		return b, true
+	}
+	return 0, false
+}
+
+// Screen and keyboard
+
+func (k *C64Kernal) print(b byte) {
+	switch b {
+	case 0x0D, 0x8D:
+		k.row, k.column = k.row+1, 0
+		fmt.Fprint(k.Out, "\n")
+	case 0x93:
+		k.row, k.column = 0, 0
+		fmt.Fprint(k.Out, "\x1b[2J\x1b[H")
+	case 0x13:
+		k.row, k.column = 0, 0
+		fmt.Fprint(k.Out, "\x1b[H")
+	case 0x11:
+		k.row++
+		fmt.Fprint(k.Out, "\x1b[B")
+	case 0x91:
+		k.row = max(k.row-1, 0)
+		fmt.Fprint(k.Out, "\x1b[A")
+	case 0x1D:
+		k.column++
+		fmt.Fprint(k.Out, "\x1b[C")
+	case 0x9D:
+		k.column = max(k.column-1, 0)
+		fmt.Fprint(k.Out, "\x1b[D")
+	case 0x14:
+		if k.column > 0 {
+			k.column--
+			fmt.Fprint(k.Out, "\b \b")
+		}
+	case 0x0E:
+		k.lower = true
+	case 0x8E:
+		k.lower = false
+	default:
+		if b < 0x20 || b >= 0x80 && b < 0xA0 {
+			// colors and the rest of the control codes
+			return
+		}
+		fmt.Fprintf(k.Out, "%c", k.ascii(b))
+		k.column++
+		if k.column == 40 {
+			k.row, k.column = k.row+1, 0
+			fmt.Fprint(k.Out, "\n")
+		}
+	}
+}
+
+// readLine is the screen editor: keys are echoed until RETURN, and CHRIN
+// then hands the line out a character at a time. It reports false when
+// the input has run out.
+func (k *C64Kernal) readLine() bool {
+	for {
+		b, ok := <-k.keys
+		if !ok {
+			return false
+		}
+
+		key, ok := k.petscii(b)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == 0x0D:
+			k.line = append(k.line, key)
+			if k.echo {
+				k.print(key)
+			}
+			return true
+		case key == 0x14:
+			if len(k.line) == 0 {
+				continue
+			}
+			k.line = k.line[:len(k.line)-1]
+		default:
+			k.line = append(k.line, key)
+		}
+		if k.echo {
+			k.print(key)
+		}
+	}
+}
+
+func (k *C64Kernal) chrout() bool {
+	if k.output == nil || k.output.f == nil {
+		k.print(cpu.A)
+	} else if _, err := k.output.f.Write([]byte{cpu.A}); err != nil {
+		k.setStatus(0x01)
+	}
+
+	k.succeed()
+	return false
+}
+
+func (k *C64Kernal) chrin() bool {
+	if k.input != nil && k.input.f != nil {
+		k.setA(k.readFile(k.input))
+		return false
+	}
+
+	if len(k.line) == 0 && !k.readLine() {
+		return true
+	}
+	k.setA(k.line[0])
+	k.line = k.line[1:]
+	return false
+}
+
+func (k *C64Kernal) getin() bool {
+	if k.input != nil && k.input.f != nil {
+		k.setA(k.readFile(k.input))
+		return false
+	}
+
+	select {
+	case b, ok := <-k.keys:
+		if !ok {
+			return true
+		}
+		key, _ := k.petscii(b)
+		k.setA(key)
+	default:
+		k.setA(0)
+	}
+	return false
+}
+
+// plot reads the cursor into X (row) and Y (column) with carry set, and
+// moves it there with carry clear.
+func (k *C64Kernal) plot() bool {
+	if getFlag(FlagC) == 1 {
+		cpu.X, cpu.Y = byte(k.row), byte(k.column)
+	} else {
+		k.row, k.column = int(cpu.X), int(cpu.Y)
+		fmt.Fprintf(k.Out, "\x1b[%d;%dH", k.row+1, k.column+1)
+	}
+
+	k.succeed()
+	return false
+}
+
+// Files
+
+func (k *C64Kernal) readst() bool {
+	k.setA(k.status)
+	return false
+}
+
+func (k *C64Kernal) setlfs() bool {
+	k.file, k.device, k.second = cpu.A, cpu.X, cpu.Y
+	return false
+}
+
+func (k *C64Kernal) setnam() bool {
+	address := uint16(cpu.X) | uint16(cpu.Y)<<8
+	name := make([]byte, cpu.A)
+	for i := range name {
+		name[i] = k.ascii(cpu.Bus.Read(address + uint16(i)))
+	}
+	k.name = string(name)
+	return false
+}
+
+// hostName drops a drive prefix like 0: and the ,S,W style options, and
+// lowercases what is left. It also returns the os.OpenFile flags for the
+// mode the options ask for: reading, writing a new file or appending.
+func (k *C64Kernal) hostName() (string, int) {
+	name, options, _ := strings.Cut(k.name, ",")
+	if _, after, ok := strings.Cut(name, ":"); ok {
+		name = after
+	}
+
+	flag := os.O_RDONLY
+	if k.second == 1 {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	for _, option := range strings.Split(options, ",") {
+		switch strings.ToUpper(option) {
+		case "W":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		case "A":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		case "R":
+			flag = os.O_RDONLY
+		}
+	}
+	return strings.ToLower(name), flag
+}
+
+// isDisk tells the devices kept as host files, the tape and the drives,
+// from the keyboard (0) and the screen (3).
+func (k *C64Kernal) isDisk() bool {
+	return k.device == 1 || k.device >= 8
+}
+
+func (k *C64Kernal) open() bool {
+	if _, ok := k.files[k.file]; ok {
+		k.fail(c64FileOpen)
+		return false
+	}
+
+	file := &c64File{device: k.device, write: k.device == 3}
+	switch {
+	case k.device == 0 || k.device == 3:
+	case k.isDisk():
+		name, flag := k.hostName()
+		if name == "" {
+			k.fail(c64MissingFileName)
+			return false
+		}
+
+		var err error
+		file.f, err = k.Root.OpenFile(name, flag, 0o644)
+		if err != nil {
+			k.fail(c64FileNotFound)
+			return false
+		}
+		file.write = flag != os.O_RDONLY
+		if !file.write {
+			file.r = bufio.NewReader(file.f)
+		}
+	default:
+		k.fail(c64DeviceNotPresent)
+		return false
+	}
+
+	k.files[k.file] = file
+	k.clearStatus()
+	k.succeed()
+	return false
+}
+
+func (k *C64Kernal) close() bool {
+	if file, ok := k.files[cpu.A]; ok {
+		if file.f != nil {
+			file.f.Close()
+		}
+		if k.input == file {
+			k.input = nil
+		}
+		if k.output == file {
+			k.output = nil
+		}
+		delete(k.files, cpu.A)
+	}
+
+	k.succeed()
+	return false
+}
+
+func (k *C64Kernal) chkin() bool {
+	file, ok := k.files[cpu.X]
+	switch {
+	case !ok:
+		k.fail(c64FileNotOpen)
+	case file.write:
+		k.fail(c64NotInputFile)
+	default:
+		k.input = file
+		k.succeed()
+	}
+	return false
+}
+
+func (k *C64Kernal) chkout() bool {
+	file, ok := k.files[cpu.X]
+	switch {
+	case !ok:
+		k.fail(c64FileNotOpen)
+	case !file.write:
+		k.fail(c64NotOutputFile)
+	default:
+		k.output = file
+		k.succeed()
+	}
+	return false
+}
+
+func (k *C64Kernal) clrchn() bool {
+	k.input, k.output = nil, nil
+	return false
+}
+
+// readFile returns the next byte, flagging end of file in ST along with
+// the last one. Past the end it returns RETURN with a read error.
+func (k *C64Kernal) readFile(file *c64File) byte {
+	b, err := file.r.ReadByte()
+	if err != nil {
+		k.setStatus(0x42)
+		return 0x0D
+	}
+
+	if _, err := file.r.Peek(1); err != nil {
+		k.setStatus(0x40)
+	}
+	return b
+}
+
+// load reads a PRG to its own address with secondary address 1, or to
+// X/Y otherwise. A non-zero A verifies instead. The end address comes
+// back in X/Y.
+func (k *C64Kernal) load() bool {
+	verify := cpu.A != 0
+	if !k.isDisk() {
+		k.fail(c64DeviceNotPresent)
+		return false
+	}
+
+	name, _ := k.hostName()
+	if name == "" {
+		k.fail(c64MissingFileName)
+		return false
+	}
+
+	data, err := k.Root.ReadFile(name)
+	if err != nil {
+		data, err = k.Root.ReadFile(name + ".prg")
+	}
+	if err != nil || len(data) < 2 {
+		k.fail(c64FileNotFound)
+		return false
+	}
+
+	address := uint16(data[0]) | uint16(data[1])<<8
+	if k.second == 0 {
+		address = uint16(cpu.X) | uint16(cpu.Y)<<8
+	}
+
+	k.clearStatus()
+	for _, b := range data[2:] {
+		if !verify {
+			cpu.Bus.Write(address, b)
+		} else if cpu.Bus.Read(address) != b {
+			k.setStatus(0x10)
+		}
+		address++
+	}
+	k.setStatus(0x40)
+
+	cpu.X, cpu.Y = byte(address), byte(address>>8)
+	k.succeed()
+	return false
+}
+
+// save writes from the address in the zero page pair A points to, up to
+// X/Y, as a PRG.
+func (k *C64Kernal) save() bool {
+	if !k.isDisk() {
+		k.fail(c64DeviceNotPresent)
+		return false
+	}
+
+	name, _ := k.hostName()
+	if name == "" {
+		k.fail(c64MissingFileName)
+		return false
+	}
+
+	start := uint16(cpu.Bus.Read(uint16(cpu.A))) | uint16(cpu.Bus.Read(uint16(cpu.A)+1))<<8
+	end := uint16(cpu.X) | uint16(cpu.Y)<<8
+	data := []byte{byte(start), byte(start >> 8)}
+	for address := start; address < end; address++ {
+		data = append(data, cpu.Bus.Read(address))
+	}
+
+	if err := k.Root.WriteFile(name, data, 0o644); err != nil {
+		k.fail(c64DeviceNotPresent)
+		return false
+	}
+
+	k.clearStatus()
+	k.succeed()
+	return false
+}
+
+// Close puts the terminal back and closes the files the program left
+// open.
+func (k *C64Kernal) Close() error {
+	for _, file := range k.files {
+		if file.f != nil {
+			file.f.Close()
+		}
+	}
+	k.restore()
+	return k.Root.Close()
+}
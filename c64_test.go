@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// c64CPU sets up a C64 with a PRG that only returns, its disk files in a
+// directory of their own. It returns the directory that one is in, which
+// programs must not reach.
+func c64CPU(t *testing.T) (*CPU, *C64Kernal, string) {
+	t.Helper()
+	saved := cpu
+	t.Cleanup(func() { cpu = saved })
+
+	cpu = &CPU{Frequency: 1e6}
+	cpu.Rom = []byte{0x00, 0xC0, 0x60}
+	if err := setupC64(cpu); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cpu.Bus.Close)
+	cpu.Reset()
+
+	k := cpu.Bus.lookup(c64Kernal).device.(*C64Kernal)
+	k.Root.Close()
+	outside := t.TempDir()
+	disk := filepath.Join(outside, "disk")
+	if err := os.Mkdir(disk, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.OpenRoot(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Root = root
+	return cpu, k, outside
+}
+
+// kernalCall calls the KERNAL routine at address with A, X and Y, and
+// returns A and whether carry came back set, for an error.
+func kernalCall(t *testing.T, c *CPU, address uint16, a, x, y byte) (byte, bool) {
+	t.Helper()
+	c.A, c.X, c.Y = a, x, y
+	if c.Traps[address]() {
+		t.Fatalf("the call to $%04X ended the program", address)
+	}
+	return c.A, getFlag(FlagC) == 1
+}
+
+// kernalOpen names and opens a file and returns the error code, or 0.
+func kernalOpen(t *testing.T, c *CPU, file, device, second byte, name string) byte {
+	t.Helper()
+	copy(c.Ram[0xC100:], name)
+	kernalCall(t, c, 0xFFBD, byte(len(name)), 0x00, 0xC1)
+	kernalCall(t, c, 0xFFBA, file, device, second)
+	if code, failed := kernalCall(t, c, 0xFFC0, 0, 0, 0); failed {
+		return code
+	}
+	return 0
+}
+
+func kernalWrite(t *testing.T, c *CPU, file byte, text string) {
+	t.Helper()
+	if code, failed := kernalCall(t, c, 0xFFC9, 0, file, 0); failed {
+		t.Fatalf("CHKOUT failed with %d", code)
+	}
+	for _, b := range []byte(text) {
+		kernalCall(t, c, 0xFFD2, b, 0, 0)
+	}
+	kernalCall(t, c, 0xFFCC, 0, 0, 0)
+	kernalCall(t, c, 0xFFC3, file, 0, 0)
+}
+
+func TestC64WriteAndRead(t *testing.T) {
+	c, _, outside := c64CPU(t)
+	if code := kernalOpen(t, c, 1, 8, 2, "0:DATA,S,W"); code != 0 {
+		t.Fatalf("OPEN for writing failed with %d", code)
+	}
+	kernalWrite(t, c, 1, "HI")
+	if data, err := os.ReadFile(filepath.Join(outside, "disk", "data")); err != nil || string(data) != "HI" {
+		t.Fatalf("file holds %q, %v", data, err)
+	}
+
+	if code := kernalOpen(t, c, 2, 8, 2, "DATA,S,R"); code != 0 {
+		t.Fatalf("OPEN for reading failed with %d", code)
+	}
+	if code, failed := kernalCall(t, c, 0xFFC6, 0, 2, 0); failed {
+		t.Fatalf("CHKIN failed with %d", code)
+	}
+	for i, want := range []byte("HI") {
+		if got, _ := kernalCall(t, c, 0xFFCF, 0, 0, 0); got != want {
+			t.Errorf("CHRIN = %q, want %q", got, want)
+		}
+		status, _ := kernalCall(t, c, 0xFFB7, 0, 0, 0)
+		if last := i == 1; (status&0x40 != 0) != last {
+			t.Errorf("ST = $%02X after byte %d of 2", status, i+1)
+		}
+	}
+}
+
+func TestC64Append(t *testing.T) {
+	c, _, outside := c64CPU(t)
+	path := filepath.Join(outside, "disk", "log")
+	if err := os.WriteFile(path, []byte("AB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := kernalOpen(t, c, 1, 8, 2, "LOG,S,A"); code != 0 {
+		t.Fatalf("OPEN for appending failed with %d", code)
+	}
+	kernalWrite(t, c, 1, "C")
+	if data, _ := os.ReadFile(path); string(data) != "ABC" {
+		t.Errorf("file holds %q after appending, want %q", data, "ABC")
+	}
+}
+
+func TestC64SaveAndLoad(t *testing.T) {
+	c, _, outside := c64CPU(t)
+	copy(c.Ram[0x2000:], []byte{1, 2, 3, 4})
+	c.Ram[0xFB], c.Ram[0xFC] = 0x00, 0x20
+
+	copy(c.Ram[0xC100:], "PROG")
+	kernalCall(t, c, 0xFFBD, 4, 0x00, 0xC1)
+	kernalCall(t, c, 0xFFBA, 1, 8, 0)
+	if code, failed := kernalCall(t, c, 0xFFD8, 0xFB, 0x04, 0x20); failed {
+		t.Fatalf("SAVE failed with %d", code)
+	}
+	data, err := os.ReadFile(filepath.Join(outside, "disk", "prog"))
+	if err != nil || string(data) != "\x00\x20\x01\x02\x03\x04" {
+		t.Fatalf("saved % X, %v", data, err)
+	}
+
+	// to its own address with secondary address 1
+	clear(c.Ram[0x2000:0x2004])
+	kernalCall(t, c, 0xFFBA, 1, 8, 1)
+	if code, failed := kernalCall(t, c, 0xFFD5, 0, 0, 0); failed {
+		t.Fatalf("LOAD failed with %d", code)
+	}
+	if string(c.Ram[0x2000:0x2004]) != "\x01\x02\x03\x04" || c.X != 0x04 || c.Y != 0x20 {
+		t.Errorf("loaded % X, end $%02X%02X", c.Ram[0x2000:0x2004], c.Y, c.X)
+	}
+}
+
+func TestC64StaysInRoot(t *testing.T) {
+	c, _, outside := c64CPU(t)
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte{0x00, 0x20, 0xFF}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := kernalOpen(t, c, 1, 8, 2, "../SECRET,S,R"); code != c64FileNotFound {
+		t.Errorf("OPEN outside the root returned %d", code)
+	}
+
+	copy(c.Ram[0xC100:], "../SECRET")
+	kernalCall(t, c, 0xFFBD, 9, 0x00, 0xC1)
+	kernalCall(t, c, 0xFFBA, 1, 8, 1)
+	if code, failed := kernalCall(t, c, 0xFFD5, 0, 0, 0); !failed || code != c64FileNotFound {
+		t.Errorf("LOAD outside the root returned %d", code)
+	}
+	if c.Ram[0x2000] != 0 {
+		t.Error("LOAD read a file outside the root")
+	}
+
+	copy(c.Ram[0xC100:], "../OUT")
+	kernalCall(t, c, 0xFFBD, 6, 0x00, 0xC1)
+	if _, failed := kernalCall(t, c, 0xFFD8, 0xFB, 0x00, 0x00); !failed {
+		t.Error("SAVE outside the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "out")); !os.IsNotExist(err) {
+		t.Errorf("SAVE wrote outside the root: %v", err)
+	}
+}
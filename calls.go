@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// CallFrame is a call the CPU is inside: a JSR, or a BRK, IRQ or NMI
+// entering its handler. The shadow call stack keeps one for every call
+// not yet returned from, from watching JSR and RTS, BRK, the interrupt
+// sequences and RTI.
+type CallFrame struct {
+	// Kind is "JSR", "BRK", "IRQ" or "NMI"
+	Kind string
+	// From is the JSR or BRK instruction, or where the interrupt came
+	From uint16
+	// To is the routine or handler called
+	To uint16
+	// Return is the address pushed, and S the stack pointer after the
+	// push, where the matching return pulls it from
+	Return uint16
+	S      byte
+}
+
+// CallStack returns the calls the CPU is inside, the innermost first.
+func (c *CPU) CallStack() []CallFrame {
+	frames := make([]CallFrame, len(c.calls))
+	for i, frame := range c.calls {
+		frames[len(frames)-1-i] = frame
+	}
+	return frames
+}
+
+// called pushes a frame. Frames at or below the new one's S have had their
+// stack space given up, by code dropping return addresses or resetting
+// S, and are dropped too.
+func (c *CPU) called(kind string, from, to, ret uint16) {
+	c.dropAbandoned(c.S + 1)
+	c.Profile.called(c.calls, from, to)
+	c.Profile.moved()
+	c.calls = append(c.calls, CallFrame{Kind: kind, From: from, To: to, Return: ret, S: c.S})
+}
+
+// returned matches an RTS (interrupt false) or RTI that pulled ret with S
+// at s before the pull. Code that handles the stack itself can return
+// from somewhere with no frame, or to an address other than the one
+// pushed: that is a mismatch, counted in CallMismatches. A return with
+// no frame at all fails the returns check.
+func (c *CPU) returned(interrupt bool, s byte, ret uint16) {
+	c.dropAbandoned(s)
+
+	top := len(c.calls) - 1
+	if top < 0 || c.calls[top].S != s || (c.calls[top].Kind == "JSR") == interrupt {
+		c.CallMismatches++
+		if c.Diagnose&DiagnoseReturns != 0 {
+			c.fail(ErrUnmatchedReturn)
+		}
+		return
+	}
+
+	if c.calls[top].Return != ret {
+		c.CallMismatches++
+	}
+	c.calls = c.calls[:top]
+	c.Profile.moved()
+}
+
+// dropAbandoned drops the frames whose return addresses sit below s,
+// where the stack no longer reaches.
+func (c *CPU) dropAbandoned(s byte) {
+	top := len(c.calls)
+	for top > 0 && c.calls[top-1].S < s {
+		top--
+	}
+	if top < len(c.calls) {
+		c.CallMismatches += uint64(len(c.calls) - top)
+		c.calls = c.calls[:top]
+		c.Profile.moved()
+	}
+}
+
+// writeBacktrace writes the call stack for the instruction at pc, the
+// innermost call first, with symbol names if there are any.
+func writeBacktrace(w io.Writer, pc uint16, frames []CallFrame) {
+	fmt.Fprintf(w, "  #0  %s\n", symbolic(pc))
+	for i, frame := range frames {
+		fmt.Fprintf(w, "  #%-2d %s, %s to %s\n", i+1, symbolic(frame.From), frame.Kind, symbolic(frame.To))
+	}
+}
+
+// symbolic shows an address with its symbol name, if it has one.
+func symbolic(address uint16) string {
+	if name := cpu.Symbols.Name(address); name != "" {
+		return fmt.Sprintf("$%04X %s", address, name)
+	}
+	return fmt.Sprintf("$%04X", address)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// callProgram has Main at $8000 call Outer at $8010, which calls Inner at
+// $8020, then halt.
+func callProgram() []byte {
+	program := make([]byte, 0x30)
+	copy(program, []byte{0x20, 0x10, 0x80, 0xEA})        // JSR Outer, NOP
+	copy(program[0x10:], []byte{0x20, 0x20, 0x80, 0x60}) // JSR Inner, RTS
+	program[0x20] = 0x60                                 // RTS
+	return program
+}
+
+func TestCallStack(t *testing.T) {
+	c := testCPU(t, callProgram()...)
+	s := c.S
+	stepN(t, c, 2)
+
+	want := []CallFrame{
+		{Kind: "JSR", From: 0x8010, To: 0x8020, Return: 0x8012, S: s - 4},
+		{Kind: "JSR", From: 0x8000, To: 0x8010, Return: 0x8002, S: s - 2},
+	}
+	if got := c.CallStack(); !reflect.DeepEqual(got, want) {
+		t.Errorf("call stack %+v, want %+v", got, want)
+	}
+
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Fatalf("Run returned %v", err)
+	}
+	if len(c.CallStack()) != 0 || c.CallMismatches != 0 {
+		t.Errorf("call stack %+v with %d mismatches after the returns", c.CallStack(), c.CallMismatches)
+	}
+}
+
+func TestCallStackInterrupts(t *testing.T) {
+	// CLI, JMP *, with RTI as the IRQ handler at $8010 and BRK, RTI at $8020
+	program := make([]byte, 0x8000)
+	copy(program, []byte{0x58, 0x4C, 0x01, 0x80})
+	program[0x10] = 0x40
+	copy(program[0x20:], []byte{0x00, 0xEA, 0x40})
+	program[0x7FFE], program[0x7FFF] = 0x10, 0x80
+	c := testCPU(t, program...)
+	line := c.NewIRQLine()
+	stepN(t, c, 2)
+
+	c.SetIRQ(line, true)
+	stepN(t, c, 1)
+	c.SetIRQ(line, false)
+
+	// a BRK from inside the handler
+	c.PC = 0x8020
+	stepN(t, c, 1)
+	want := []CallFrame{
+		{Kind: "BRK", From: 0x8020, To: 0x8010, Return: 0x8022, S: c.S},
+		{Kind: "IRQ", From: 0x8001, To: 0x8010, Return: 0x8001, S: c.S + 3},
+	}
+	if got := c.CallStack(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("call stack %+v, want %+v", got, want)
+	}
+
+	stepN(t, c, 2)
+	if c.PC != 0x8001 || len(c.CallStack()) != 0 || c.CallMismatches != 0 {
+		t.Errorf("PC $%04X, call stack %+v with %d mismatches after the RTIs", c.PC, c.CallStack(), c.CallMismatches)
+	}
+}
+
+func TestCallMismatches(t *testing.T) {
+	// JSR $8010, then LDX #$FF, TXS, JSR $8020 at $8010; RTS at $8020
+	program := make([]byte, 0x30)
+	copy(program, []byte{0x20, 0x10, 0x80})
+	copy(program[0x10:], []byte{0xA2, 0xFF, 0x9A, 0x20, 0x20, 0x80})
+	program[0x20] = 0x60
+	c := testCPU(t, program...)
+	stepN(t, c, 4)
+
+	// resetting S abandoned the first call
+	stack := c.CallStack()
+	if len(stack) != 1 || stack[0].From != 0x8013 || c.CallMismatches != 1 {
+		t.Errorf("call stack %+v with %d mismatches, want only the call from $8013 and 1", stack, c.CallMismatches)
+	}
+
+	// an RTS to somewhere else than pushed still pops the frame
+	c.Write(0x1FE, 0x00)
+	stepN(t, c, 1)
+	if len(c.CallStack()) != 0 || c.CallMismatches != 2 {
+		t.Errorf("call stack %+v with %d mismatches after the RTS", c.CallStack(), c.CallMismatches)
+	}
+
+	// and an RTS with no frame at all fails the returns check
+	c.Diagnose |= DiagnoseReturns
+	c.PC = 0x8020
+	if err := c.Step(); !errors.Is(err, ErrUnmatchedReturn) {
+		t.Errorf("Step returned %v, want ErrUnmatchedReturn", err)
+	}
+}
+
+func TestWriteBacktrace(t *testing.T) {
+	c := testCPU(t, callProgram()...)
+	path := filepath.Join(t.TempDir(), "calls.lbl")
+	labels := "al 008000 .Main\nal 008010 .Outer\nal 008020 .Inner\n"
+	if err := os.WriteFile(path, []byte(labels), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if c.Symbols, err = LoadSymbols(path); err != nil {
+		t.Fatal(err)
+	}
+	stepN(t, c, 2)
+
+	var out bytes.Buffer
+	writeBacktrace(&out, c.PC, c.CallStack())
+	want := "  #0  $8020 Inner\n" +
+		"  #1  $8010 Outer, JSR to $8020 Inner\n" +
+		"  #2  $8000 Main, JSR to $8010 Outer\n"
+	if out.String() != want {
+		t.Errorf("backtrace\n%s\nwant\n%s", out.String(), want)
+	}
+}
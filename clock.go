@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Clock throttles the CPU to a target frequency. Every slice of emulated
+// time it sleeps until the wall clock catches up with the cycle count.
+// Sleep targets are measured from a fixed anchor, so oversleeping in one
+// slice is made up in the next instead of adding up. When the host falls
+// too far behind the anchor is moved rather than running flat out to
+// catch up.
+type Clock struct {
+	// Hz is the target frequency, 0 runs unthrottled
+	Hz float64
+
+	turbo atomic.Bool
+	cpu   *CPU
+
+	anchorTime   time.Time
+	anchorCycles uint64
+	startTime    time.Time
+	startCycles  uint64
+	slice        uint64
+}
+
+const (
+	clockSlice  = 10 * time.Millisecond
+	clockMaxLag = 100 * time.Millisecond
+)
+
+// ParseClock reads a frequency like "1MHz", "1.79", "2mhz" or "unlimited".
+// Plain numbers are MHz. Unlimited is 0.
+func ParseClock(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "unlimited" || s == "0" {
+		return 0, nil
+	}
+
+	mhz, err := strconv.ParseFloat(strings.TrimSuffix(s, "mhz"), 64)
+	if err != nil || mhz < 0 || math.IsNaN(mhz) || math.IsInf(mhz, 0) {
+		return 0, fmt.Errorf("bad clock speed %q", s)
+	}
+
+	return mhz * 1e6, nil
+}
+
+// Start sets the CPU to the target frequency and starts throttling it.
+// Devices work out their timing from the frequency, so the clock starts
+// before they are created.
+func (k *Clock) Start(c *CPU) {
+	k.cpu = c
+	now := time.Now()
+	k.startTime, k.startCycles = now, c.Cycles
+	k.anchorTime, k.anchorCycles = now, c.Cycles
+	if k.Hz == 0 {
+		return
+	}
+
+	c.Frequency = k.Hz
+	k.slice = uint64(k.Hz * clockSlice.Seconds())
+	if k.slice == 0 {
+		k.slice = 1
+	}
+	c.After(k.slice, k.throttle)
+}
+
+func (k *Clock) throttle(cycle uint64) {
+	k.cpu.Scheduler.At(cycle+k.slice, k.throttle)
+
+	now := time.Now()
+	if k.turbo.Load() {
+		k.anchorTime, k.anchorCycles = now, cycle
+		return
+	}
+
+	elapsed := time.Duration(float64(cycle-k.anchorCycles) / k.Hz * float64(time.Second))
+	ahead := k.anchorTime.Add(elapsed).Sub(now)
+	switch {
+	case ahead > 0:
+		time.Sleep(ahead)
+	case ahead < -clockMaxLag:
+		k.anchorTime, k.anchorCycles = now, cycle
+	}
+}
+
+// SetTurbo runs the CPU unthrottled until turned off again. It is safe to
+// call from any goroutine.
+func (k *Clock) SetTurbo(on bool) {
+	k.turbo.Store(on)
+}
+
+func (k *Clock) ToggleTurbo() {
+	k.SetTurbo(!k.turbo.Load())
+}
+
+// EffectiveMHz is the speed achieved since Start.
+func (k *Clock) EffectiveMHz() float64 {
+	seconds := time.Since(k.startTime).Seconds()
+	if seconds == 0 {
+		return 0
+	}
+
+	return float64(k.cpu.Cycles-k.startCycles) / seconds / 1e6
+}
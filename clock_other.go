@@ -0,0 +1,6 @@
+//go:build windows || plan9
+
+package main
+
+// toggleTurboOnSignal does nothing where there is no SIGUSR1.
+func toggleTurboOnSignal(*Clock) {}
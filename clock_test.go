@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseClock(t *testing.T) {
+	for s, hz := range map[string]float64{
+		"unlimited": 0,
+		"1MHz":      1e6,
+		"1.79mhz":   1.79e6,
+		" 2 ":       2e6,
+	} {
+		got, err := ParseClock(s)
+		if err != nil || got != hz {
+			t.Errorf("ParseClock(%q) = %v, %v, want %v", s, got, err, hz)
+		}
+	}
+
+	for _, s := range []string{"fast", "-1MHz", "NaN", "Inf", "+Inf", "-inf", "1e400"} {
+		if _, err := ParseClock(s); err == nil {
+			t.Errorf("ParseClock(%q) succeeded", s)
+		}
+	}
+}
+
+func TestClockThrottlesItsCPU(t *testing.T) {
+	c := &CPU{Frequency: 1e6}
+	k := &Clock{Hz: 1e8}
+	k.Start(c)
+	if c.Frequency != 1e8 {
+		t.Errorf("Frequency = %g after Start, want 1e8", c.Frequency)
+	}
+
+	next, ok := c.Scheduler.Next()
+	if !ok || next != k.slice {
+		t.Fatalf("first slice ends at %d, %v, want %d", next, ok, k.slice)
+	}
+	c.Cycles = next
+	c.Scheduler.Run(c.Cycles)
+	if next, _ := c.Scheduler.Next(); next != 2*k.slice {
+		t.Errorf("second slice ends at %d, want %d", next, 2*k.slice)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// toggleTurboOnSignal flips turbo mode on every SIGUSR1.
+func toggleTurboOnSignal(k *Clock) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			k.ToggleTurbo()
+		}
+	}()
+}
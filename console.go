@@ -6,7 +6,7 @@ type Console struct {
 	ReadBuf []byte
 }
 
-func (c *Console) Read() byte {
+func (c *Console) Read(uint16) byte {
 	if len(c.ReadBuf) == 0 {
 		_, _ = fmt.Scanln(&c.ReadBuf)
 		c.ReadBuf = append(c.ReadBuf, '\n')
@@ -16,6 +16,6 @@ func (c *Console) Read() byte {
 	return result
 }
 
-func (c Console) Write(value byte) {
+func (c *Console) Write(_ uint16, value byte) {
 	fmt.Printf("%c", rune(value))
 }
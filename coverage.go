@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Coverage records which addresses were run as opcodes, how often each
+// branch went each way and which addresses were read as data, leaving
+// out operand fetches and dummy accesses. Reports map them onto source
+// lines with the debug info of the program.
+type Coverage struct {
+	executed [0x10000]uint32
+	taken    [0x10000]uint32
+	notTaken [0x10000]uint32
+	data     [0x10000]bool
+
+	// instruction is where the running instruction starts and length its
+	// size, so its operand fetches are not taken for data
+	instruction uint16
+	length      uint16
+}
+
+var coverageFormats = []string{"html", "lcov"}
+
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// fetched records the opcode of an instruction about to run.
+func (v *Coverage) fetched(pc uint16, opcode byte) {
+	if v == nil {
+		return
+	}
+	v.executed[pc]++
+	v.instruction, v.length = pc, uint16(instructionLength(opcode))
+}
+
+func (v *Coverage) branched(pc uint16, taken bool) {
+	if v == nil {
+		return
+	}
+	if taken {
+		v.taken[pc]++
+	} else {
+		v.notTaken[pc]++
+	}
+}
+
+// read records a read by the CPU, if it is a data read.
+func (v *Coverage) read(c *CPU, address uint16) {
+	if v == nil || c.dummy {
+		return
+	}
+	if c.sequence == "" && (c.op == nil || address-v.instruction < v.length) {
+		// the opcode fetch or an operand
+		return
+	}
+	v.data[address] = true
+}
+
+// lineCoverage is what happened to the bytes of a source line. A code
+// line is one that ran, or that holds a single instruction and was never
+// read as data.
+type lineCoverage struct {
+	code bool
+	// count is how many times the most run instruction on the line ran
+	count uint32
+	data  bool
+	// branches are the taken and not taken counts of its branches
+	branches [][2]uint32
+}
+
+func (l *lineCoverage) class() string {
+	switch {
+	case l.code && l.count == 0:
+		return "miss"
+	case l.code:
+		for _, branch := range l.branches {
+			if branch[0] == 0 || branch[1] == 0 {
+				return "partial"
+			}
+		}
+		return "hit"
+	case l.data:
+		return "data"
+	}
+	return ""
+}
+
+// fileCoverage is the coverage of the lines of a source file.
+type fileCoverage struct {
+	path  string
+	lines map[int]*lineCoverage
+	// lines and branches found and hit
+	linesFound, linesHit       int
+	branchesFound, branchesHit int
+}
+
+// files maps the coverage onto the source lines, reading the
+// instructions from memory as it is now.
+func (v *Coverage) files(info *DebugInfo) []*fileCoverage {
+	spans := make(map[[2]int][]AddressRange)
+	for _, line := range info.Lines {
+		key := [2]int{line.File, line.Line}
+		spans[key] = append(spans[key], line.Spans...)
+	}
+
+	files := make([]*fileCoverage, len(info.Files))
+	for key, ranges := range spans {
+		if files[key[0]] == nil {
+			files[key[0]] = &fileCoverage{path: info.Files[key[0]], lines: make(map[int]*lineCoverage)}
+		}
+		file := files[key[0]]
+		line := v.line(ranges)
+		file.lines[key[1]] = line
+
+		if !line.code {
+			continue
+		}
+		file.linesFound++
+		if line.count > 0 {
+			file.linesHit++
+		}
+		for _, branch := range line.branches {
+			file.branchesFound += 2
+			for _, count := range branch {
+				if count > 0 {
+					file.branchesHit++
+				}
+			}
+		}
+	}
+
+	var found []*fileCoverage
+	for _, file := range files {
+		if file != nil {
+			found = append(found, file)
+		}
+	}
+	return found
+}
+
+func (v *Coverage) line(ranges []AddressRange) *lineCoverage {
+	line := &lineCoverage{}
+	size := 0
+	for _, r := range ranges {
+		size += r.Size
+		for i := 0; i < r.Size; i++ {
+			address := r.Start + uint16(i)
+			line.count = max(line.count, v.executed[address])
+			line.data = line.data || v.data[address]
+		}
+	}
+
+	first := cpu.Bus.Read(ranges[0].Start)
+	mnemonic, _ := opcodeMode(first)
+	line.code = line.count > 0 ||
+		!line.data && mnemonic != "" && len(ranges) == 1 && size == instructionLength(first)
+	if !line.code {
+		return line
+	}
+
+	for _, r := range ranges {
+		for i := 0; i < r.Size; {
+			address := r.Start + uint16(i)
+			opcode := cpu.Bus.Read(address)
+			if _, mode := opcodeMode(opcode); mode == "relative" {
+				line.branches = append(line.branches, [2]uint32{v.taken[address], v.notTaken[address]})
+			}
+			i += instructionLength(opcode)
+		}
+	}
+	return line
+}
+
+// WriteFile writes a report in a format of coverageFormats.
+func (v *Coverage) WriteFile(path, format string, info *DebugInfo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	files := v.files(info)
+	switch format {
+	case "html":
+		v.writeHTML(w, files)
+	case "lcov":
+		writeLcov(w, files)
+	}
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeLcov writes the tracefile format of lcov and genhtml.
+func writeLcov(w io.Writer, files []*fileCoverage) {
+	for _, file := range files {
+		fmt.Fprintln(w, "TN:")
+		fmt.Fprintf(w, "SF:%s\n", file.path)
+		for _, number := range sortedLines(file) {
+			line := file.lines[number]
+			if !line.code {
+				continue
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", number, line.count)
+			for i, branch := range line.branches {
+				for j, count := range branch {
+					if line.count == 0 {
+						fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", number, i, j)
+					} else {
+						fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", number, i, j, count)
+					}
+				}
+			}
+		}
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", file.branchesFound, file.branchesHit)
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", file.linesFound, file.linesHit)
+		fmt.Fprintln(w, "end_of_record")
+	}
+}
+
+func sortedLines(file *fileCoverage) []int {
+	numbers := make([]int, 0, len(file.lines))
+	for number := range file.lines {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+const coverageStyle = `body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 0 0.6em; text-align: left; }
+td.n, td.c { text-align: right; color: #666; }
+.source td { font-family: monospace; white-space: pre; }
+.hit { background: #d4f7d4; }
+.miss { background: #f7d4d4; }
+.partial { background: #f7efc4; }
+.data { background: #d8e4f7; }`
+
+// writeHTML writes a page with a summary and the source files, each line
+// colored by what happened to it.
+func (v *Coverage) writeHTML(w io.Writer, files []*fileCoverage) {
+	opcodes, data := 0, 0
+	for address := range v.executed {
+		if v.executed[address] > 0 {
+			opcodes++
+		}
+		if v.data[address] {
+			data++
+		}
+	}
+
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Coverage</title>\n<style>\n%s\n</style></head><body>\n", coverageStyle)
+	fmt.Fprintf(w, "<h1>Coverage</h1>\n<p>%d addresses run as opcodes, %d read as data.</p>\n", opcodes, data)
+	fmt.Fprintln(w, "<table><tr><th>File</th><th>Lines</th><th>Branches</th></tr>")
+	for i, file := range files {
+		fmt.Fprintf(w, "<tr><td><a href=\"#file%d\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			i, html.EscapeString(file.path), ratio(file.linesHit, file.linesFound), ratio(file.branchesHit, file.branchesFound))
+	}
+	fmt.Fprintln(w, "</table>")
+	fmt.Fprintln(w, "<p><span class=\"hit\">run</span> <span class=\"partial\">a branch went one way only</span> <span class=\"miss\">not run</span> <span class=\"data\">read as data</span>; after the run count, how often each branch was taken and not taken</p>")
+
+	for i, file := range files {
+		fmt.Fprintf(w, "<h2 id=\"file%d\">%s</h2>\n", i, html.EscapeString(file.path))
+		source, err := os.ReadFile(file.path)
+		if err != nil {
+			fmt.Fprintf(w, "<p>Cannot read the source: %s</p>\n", html.EscapeString(err.Error()))
+		}
+		text := strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+		numbers := sortedLines(file)
+		if last := numbers[len(numbers)-1]; last > len(text) {
+			text = append(text, make([]string, last-len(text))...)
+		}
+
+		fmt.Fprintln(w, "<table class=\"source\">")
+		for n, source := range text {
+			class, count := "", ""
+			if line := file.lines[n+1]; line != nil {
+				class = line.class()
+				switch {
+				case line.code:
+					count = fmt.Sprint(line.count)
+					for _, branch := range line.branches {
+						count += fmt.Sprintf(" %d/%d", branch[0], branch[1])
+					}
+				case line.data:
+					count = "data"
+				}
+			}
+			fmt.Fprintf(w, "<tr class=\"%s\"><td class=\"n\">%d</td><td class=\"c\">%s</td><td>%s</td></tr>\n",
+				class, n+1, count, html.EscapeString(strings.TrimRight(source, "\r")))
+		}
+		fmt.Fprintln(w, "</table>")
+	}
+	fmt.Fprintln(w, "</body></html>")
+}
+
+func ratio(hit, found int) string {
+	if found == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d of %d, %.1f%%", hit, found, 100*float64(hit)/float64(found))
+}
+
+// ParseCoverageFormat checks a -coverage-format value.
+func ParseCoverageFormat(s string) (string, error) {
+	for _, format := range coverageFormats {
+		if s == format {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown coverage format %q, choose from %s", s, strings.Join(coverageFormats, ", "))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// coverageCPU runs a loop reading a table, with a branch that goes both
+// ways, one that is never taken and a line that never runs, and returns
+// its coverage and the debug info for it in prog.s.
+func coverageCPU(t *testing.T) (*Coverage, *DebugInfo) {
+	t.Helper()
+	program := make([]byte, 0x30)
+	copy(program, []byte{
+		0xA2, 0x02, // LDX #2
+		0xBD, 0x20, 0x80, // loop: LDA table,X
+		0xCA,       // DEX
+		0xD0, 0xFA, // BNE loop
+		0x30, 0x01, // BMI *+3
+		0xEA, // NOP
+	})
+	copy(program[0x10:], []byte{0x4C, 0x00, 0x80}) // JMP $8000
+	copy(program[0x20:], []byte{0x01, 0x02, 0x03}) // table: .byte 1, 2, 3
+	c := testCPU(t, program...)
+
+	c.Coverage = NewCoverage()
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Fatalf("Run returned %v", err)
+	}
+
+	info := &DebugInfo{Files: []string{"prog.s"}}
+	for i, span := range []AddressRange{
+		{0x8000, 2}, {0x8002, 3}, {0x8005, 1}, {0x8006, 2}, {0x8008, 2}, {0x8010, 3}, {0x8020, 3},
+	} {
+		info.Lines = append(info.Lines, DebugLine{File: 0, Line: i + 1, Spans: []AddressRange{span}})
+	}
+	return c.Coverage, info
+}
+
+func TestCoverageReads(t *testing.T) {
+	v, _ := coverageCPU(t)
+
+	// LDA table,X read the table at X = 2 and 1, and its operand is not data
+	for address, want := range map[uint16]bool{
+		0x8003: false, 0x8004: false,
+		0x8020: false, 0x8021: true, 0x8022: true,
+	} {
+		if v.data[address] != want {
+			t.Errorf("$%04X read as data %v, want %v", address, v.data[address], want)
+		}
+	}
+	if v.executed[0x8002] != 2 || v.executed[0x8010] != 0 {
+		t.Errorf("ran $8002 %d times and $8010 %d times, want 2 and 0", v.executed[0x8002], v.executed[0x8010])
+	}
+	if v.taken[0x8006] != 1 || v.notTaken[0x8006] != 1 || v.taken[0x8008] != 0 || v.notTaken[0x8008] != 1 {
+		t.Errorf("BNE taken %d, not taken %d, BMI taken %d, not taken %d",
+			v.taken[0x8006], v.notTaken[0x8006], v.taken[0x8008], v.notTaken[0x8008])
+	}
+}
+
+func TestCoverageLcov(t *testing.T) {
+	v, info := coverageCPU(t)
+	var out bytes.Buffer
+	writeLcov(&out, v.files(info))
+
+	want := `TN:
+SF:prog.s
+DA:1,1
+DA:2,2
+DA:3,2
+DA:4,2
+BRDA:4,0,0,1
+BRDA:4,0,1,1
+DA:5,1
+BRDA:5,0,0,0
+BRDA:5,0,1,1
+DA:6,0
+BRF:4
+BRH:3
+LF:6
+LH:5
+end_of_record
+`
+	if out.String() != want {
+		t.Errorf("lcov output\n%s\nwant\n%s", out.String(), want)
+	}
+
+	lines := v.files(info)[0].lines
+	for number, class := range map[int]string{2: "hit", 5: "partial", 6: "miss", 7: "data"} {
+		if got := lines[number].class(); got != class {
+			t.Errorf("line %d is %q, want %q", number, got, class)
+		}
+	}
+}
+
+func TestDebugInfoFileIDs(t *testing.T) {
+	dir := t.TempDir()
+	load := func(text string) (*DebugInfo, error) {
+		path := filepath.Join(dir, "prog.dbg")
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadDebugInfo(path)
+	}
+
+	info, err := load("version\tmajor=2,minor=0\n" +
+		"file\tid=0,name=\"/src/prog.s\",size=100,mtime=0x00000000,mod=0\n" +
+		"seg\tid=0,name=\"CODE\",start=0x008000,size=0x0010,addrsize=absolute,type=ro\n" +
+		"span\tid=0,seg=0,start=2,size=3\n" +
+		"line\tid=0,file=0,line=4,span=0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Files) != 1 || info.Files[0] != "/src/prog.s" || len(info.Lines) != 1 ||
+		info.Lines[0].Line != 4 || info.Lines[0].Spans[0] != (AddressRange{0x8002, 3}) {
+		t.Errorf("loaded %+v", info)
+	}
+
+	_, err = load("file\tid=0,name=\"a.s\"\nfile\tid=2,name=\"c.s\"\n")
+	if err == nil || !strings.Contains(err.Error(), "no file with id 1") {
+		t.Errorf("LoadDebugInfo returned %v for a missing file id", err)
+	}
+}
@@ -8,56 +8,180 @@ type CPU struct {
 	X  byte
 	Y  byte
 
-	Ram     []byte
-	Rom     []byte
-	console Console
+	// Cycles counts clock cycles since power on, one per bus cycle
+	Cycles uint64
+	// BusAccurate puts the dummy reads and writes of NMOS hardware on the
+	// bus instead of only counting their cycles
+	BusAccurate bool
+	// PerCycle runs the CPU on the per-cycle core, see Tick
+	PerCycle bool
+	// Frequency is the nominal clock in Hz, devices use it to turn real
+	// time into cycles
+	Frequency float64
+	// Sandbox keeps the dialect the examples are written in: NOP ends the
+	// program and CMP compares with its operand itself, so CMP $0A tests
+	// for a newline, instead of the memory the operand points to
+	Sandbox bool
+	// Traps stand in for ROM routines: when PC reaches one of these
+	// addresses the function runs before the instruction there is fetched,
+	// and halts the program by returning true
+	Traps map[uint16]func() bool
+	// Faults is the policy for bad memory accesses
+	Faults FaultPolicy
+	// Diagnose turns on checks for program bugs
+	Diagnose Diagnostics
+	// CallMismatches counts returns that did not match the call stack,
+	// see returned
+	CallMismatches uint64
+	// Symbols names addresses in reports, nil for none
+	Symbols *Symbols
+	// Profile counts the instructions run, nil when not profiling
+	Profile *Profile
+	// Coverage records the code run and data read, nil when not recording
+	Coverage *Coverage
+	// Scheduler runs device events as the cycle count passes them
+	Scheduler Scheduler
+
+	Ram []byte
+	Rom []byte
+	Bus Bus
+
+	op   *Opcode
+	sync bool
+	// instructionPC is where the running instruction starts, and dummy
+	// marks the dummy accesses in it. sequence names the interrupt or
+	// reset sequence running instead, if one is.
+	instructionPC uint16
+	dummy         bool
+	sequence      string
+
+	// err is why the program stopped, and trace the last instructions
+	err       error
+	trace     [traceSize]TraceEntry
+	traceNext int
+	traceLen  int
+
+	// calls is the shadow call stack, the innermost call last
+	calls []CallFrame
+
+	irq        IRQLine
+	irqDevices uint
+	nmiPending bool
+
+	cycleNext    func() (struct{}, bool)
+	cycleStop    func()
+	cycleYield   func(struct{}) bool
+	pending      BusCycle
+	polled       bool
+	resetPending bool
 }
 
 func (c *CPU) Read(address uint16) byte {
-	if address < 0x2000 {
-		return c.Ram[address]
-	}
-
-	if address == 0x2000 {
-		return c.console.Read()
+	c.busCycle(address, 0, false)
+	c.Cycles++
+	c.Coverage.read(c, address)
+	value, ok := c.Bus.read(address)
+	if !ok {
+		c.fault(faultUnmapped, address, value, false)
 	}
-
-	return c.Rom[address%0x8000]
+	return value
 }
 
 func (c *CPU) Write(address uint16, value byte) {
-	if address < 0x2000 {
-		c.Ram[address] = value
-		return
+	c.busCycle(address, value, true)
+	c.Cycles++
+	if kind := c.Bus.write(address, value); kind != "" {
+		c.fault(kind, address, value, true)
 	}
+}
 
-	if address == 0x2000 {
-		c.console.Write(value)
+func (c *CPU) Reset() {
+	if c.PerCycle {
+		c.stopCycles()
+		c.resetPending = true
 		return
 	}
 
-	c.Rom[address%0x8000] = value
+	c.reset()
 }
 
-func (c *CPU) Reset() {
-	c.PC = read16(0xFFFC)
+func (c *CPU) SetConsole(console Device) error {
+	return c.Bus.Attach(0x2000, 1, console)
+}
+
+// Step runs one instruction, or the interrupt sequence if an interrupt is
+// due. Once the program has stopped it returns why: ErrHalted when it
+// ended, or a *CPUError when it went wrong.
+func (c *CPU) Step() error {
+	// an interrupt still held must not restart a stopped program
+	if c.err != nil {
+		return c.err
+	}
+
+	if c.PerCycle {
+		return c.stepCycles()
+	}
+
+	if c.interruptAsserted() {
+		c.interrupt()
+		c.Scheduler.Run(c.Cycles)
+		return c.err
+	}
+
+	err := c.execute()
+	c.Scheduler.Run(c.Cycles)
+	return err
 }
 
-func (c *CPU) SetConsole(console Console) {
-	c.console = console
+// Run steps until the program stops, or until stop returns true, when it
+// returns nil.
+func (c *CPU) Run(stop func() bool) error {
+	for !stop() {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (c *CPU) Step() bool {
+func (c *CPU) execute() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.sync = true
+	if trap := c.Traps[c.PC]; trap != nil && trap() {
+		c.err = ErrHalted
+		return c.err
+	}
+
+	c.instructionPC = c.PC
+	c.op = nil
+	if c.checkFetch(); c.err != nil {
+		return c.err
+	}
 	opcodeNum := cpu.Read(cpu.PC)
-	if opcodeNum == 0xEA {
-		return true
+	c.record(opcodeNum)
+	c.Coverage.fetched(c.instructionPC, opcodeNum)
+	if opcodeNum == 0xEA && c.Sandbox {
+		c.err = ErrHalted
+		return c.err
 	}
 
-	op := opcodes[opcodeNum]
+	op := &opcodes[opcodeNum]
+	if op.Instruction == nil {
+		c.fail(ErrInvalidOpcode)
+		return c.err
+	}
 
-	if op.Instruction != nil {
+	cpu.op = op
+	if c.Profile == nil {
 		op.Instruction(op.GetAddress())
+		return c.err
 	}
 
-	return false
+	site, start := c.Profile.site(c, c.instructionPC), c.Cycles-1
+	op.Instruction(op.GetAddress())
+	c.Profile.count(site, c.Cycles-start)
+	return c.err
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// testCPU puts a fresh sandbox CPU in place of the global one for the
+// test, with program at $8000 and the reset vector pointing there.
+func testCPU(t *testing.T, program ...byte) *CPU {
+	t.Helper()
+	saved := cpu
+	t.Cleanup(func() { cpu = saved })
+
+	cpu = &CPU{Frequency: 1e6}
+	cpu.Rom = make([]byte, 0x8000)
+	copy(cpu.Rom, program)
+	cpu.Rom[0x7FFC], cpu.Rom[0x7FFD] = 0x00, 0x80
+	if err := setupSandbox(cpu); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Faults = FaultHalt
+	cpu.Reset()
+	return cpu
+}
+
+// advance moves the clock on by cycles, running the events due.
+func advance(c *CPU, cycles uint64) {
+	c.Cycles += cycles
+	c.Scheduler.Run(c.Cycles)
+}
+
+func TestStepHalted(t *testing.T) {
+	c := testCPU(t, 0xEA)
+	for i := 0; i < 2; i++ {
+		if err := c.Step(); err != ErrHalted {
+			t.Fatalf("Step returned %v, want ErrHalted", err)
+		}
+	}
+}
+
+func TestStepInvalidOpcode(t *testing.T) {
+	// LDA #$01, then $02
+	c := testCPU(t, 0xA9, 0x01, 0x02)
+	err := c.Run(func() bool { return false })
+
+	var crash *CPUError
+	if !errors.As(err, &crash) {
+		t.Fatalf("Run returned %v, want a *CPUError", err)
+	}
+	if !errors.Is(err, ErrInvalidOpcode) || crash.PC != 0x8002 || crash.Opcode != 0x02 || crash.A != 0x01 {
+		t.Errorf("Run returned %+v", crash)
+	}
+	if got, want := err.Error(), "invalid opcode $02 at $8002"; got != want {
+		t.Errorf("error %q, want %q", got, want)
+	}
+	if c.Step() != err {
+		t.Error("Step went on after the program crashed")
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	// JMP $8000
+	c := testCPU(t, 0x4C, 0x00, 0x80)
+	if err := c.Run(func() bool { return c.Cycles >= 100 }); err != nil {
+		t.Errorf("Run returned %v, want nil", err)
+	}
+	if c.PC != 0x8000 {
+		t.Errorf("PC = $%04X, want $8000", c.PC)
+	}
+}
+
+func TestStepAfterStopWithInterrupt(t *testing.T) {
+	for _, perCycle := range []bool{false, true} {
+		// CLI, NOP; and CLI, $02
+		for _, program := range [][]byte{{0x58, 0xEA}, {0x58, 0x02}} {
+			c := testCPU(t, program...)
+			c.PerCycle = perCycle
+			err := c.Run(func() bool { return false })
+			if err == nil {
+				t.Fatal("Run returned nil")
+			}
+
+			pc, s := c.PC, c.S
+			c.SetIRQ(c.NewIRQLine(), true)
+			c.NMI()
+			for range 2 {
+				if got := c.Step(); got != err {
+					t.Errorf("per cycle %v: Step returned %v after %v", perCycle, got, err)
+				}
+			}
+			if c.Tick() != err {
+				t.Errorf("per cycle %v: Tick went on after %v", perCycle, err)
+			}
+			if c.PC != pc || c.S != s {
+				t.Errorf("per cycle %v: took an interrupt after %v", perCycle, err)
+			}
+		}
+	}
+}
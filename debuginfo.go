@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DebugInfo is the part of an ld65 debug file, written with --dbgfile,
+// that maps source lines to the addresses they were assembled to.
+type DebugInfo struct {
+	// Files are the source files by id, their paths found relative to
+	// the debug file if they are there
+	Files []string
+	Lines []DebugLine
+}
+
+// DebugLine is a source line and the bytes assembled from it.
+type DebugLine struct {
+	File  int
+	Line  int
+	Spans []AddressRange
+}
+
+type AddressRange struct {
+	Start uint16
+	Size  int
+}
+
+func LoadDebugInfo(path string) (*DebugInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// lines come before the segments and spans they refer to, so
+	// everything is read before it is put together
+	files := make(map[int]string)
+	segments := make(map[int]int)
+	spans := make(map[int][3]int)
+	var lines []map[string]string
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, debugLineMax)
+	for number := 1; scanner.Scan(); number++ {
+		kind, fields, ok := parseDebugLine(scanner.Text())
+		if !ok {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: not an ld65 debug file line", path, number)
+		}
+
+		id, _ := strconv.Atoi(fields["id"])
+		switch kind {
+		case "file":
+			files[id] = fields["name"]
+		case "seg":
+			start, err := strconv.ParseInt(fields["start"], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: bad segment start", path, number)
+			}
+			segments[id] = int(start)
+		case "span":
+			segment, err1 := strconv.Atoi(fields["seg"])
+			start, err2 := strconv.Atoi(fields["start"])
+			size, err3 := strconv.Atoi(fields["size"])
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("%s:%d: bad span", path, number)
+			}
+			spans[id] = [3]int{segment, start, size}
+		case "line":
+			if fields["span"] != "" {
+				lines = append(lines, fields)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	info := &DebugInfo{}
+	for id := 0; id < len(files); id++ {
+		name, ok := files[id]
+		if !ok {
+			return nil, fmt.Errorf("%s: no file with id %d", path, id)
+		}
+		if !filepath.IsAbs(name) {
+			if local := filepath.Join(filepath.Dir(path), name); fileExists(local) {
+				name = local
+			}
+		}
+		info.Files = append(info.Files, name)
+	}
+
+	for _, fields := range lines {
+		file, err1 := strconv.Atoi(fields["file"])
+		line, err2 := strconv.Atoi(fields["line"])
+		if err1 != nil || err2 != nil || file < 0 || file >= len(info.Files) {
+			return nil, fmt.Errorf("%s: bad line %s", path, fields["id"])
+		}
+
+		debugLine := DebugLine{File: file, Line: line}
+		for _, spanID := range strings.Split(fields["span"], "+") {
+			id, err := strconv.Atoi(spanID)
+			span, ok := spans[id]
+			if err != nil || !ok {
+				return nil, fmt.Errorf("%s: line %s refers to a missing span", path, fields["id"])
+			}
+			start := segments[span[0]] + span[1]
+			debugLine.Spans = append(debugLine.Spans, AddressRange{Start: uint16(start), Size: span[2]})
+		}
+		info.Lines = append(info.Lines, debugLine)
+	}
+	return info, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
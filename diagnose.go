@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Diagnostics are checks for program bugs that real hardware lets pass.
+// A failed check stops the program with a CPUError.
+type Diagnostics uint
+
+const (
+	// DiagnoseStack catches S wrapping around page 1: a push with S at
+	// $00 or a pull with S at $FF
+	DiagnoseStack Diagnostics = 1 << iota
+	// DiagnoseReturns catches RTS with no JSR to return from and RTI with
+	// no BRK or interrupt, going by the call stack. Code that jumps by
+	// pushing an address and returning to it trips this.
+	DiagnoseReturns
+	// DiagnoseRunaway catches the CPU running off its code: into
+	// zero-filled memory, a BRK followed by two more zeros, or to an
+	// address with no RAM or ROM, only I/O registers or nothing at all
+	DiagnoseRunaway
+
+	DiagnoseAll = DiagnoseStack | DiagnoseReturns | DiagnoseRunaway
+)
+
+var diagnosticNames = map[string]Diagnostics{
+	"stack":   DiagnoseStack,
+	"returns": DiagnoseReturns,
+	"runaway": DiagnoseRunaway,
+	"all":     DiagnoseAll,
+}
+
+var (
+	ErrStackOverflow   = errors.New("stack overflow")
+	ErrStackUnderflow  = errors.New("stack underflow")
+	ErrUnmatchedReturn = errors.New("return without a call")
+	ErrRunaway         = errors.New("runaway execution into zero-filled memory")
+	ErrRunawayNoMemory = errors.New("runaway execution outside RAM and ROM")
+)
+
+// ParseDiagnostics reads a comma separated list of checks, such as
+// "stack,returns", or "all".
+func ParseDiagnostics(s string) (Diagnostics, error) {
+	var d Diagnostics
+	for _, name := range strings.Split(s, ",") {
+		check, ok := diagnosticNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown check %q, choose from stack, returns, runaway or all", name)
+		}
+		d |= check
+	}
+	return d, nil
+}
+
+func (c *CPU) checkPush() {
+	if c.Diagnose&DiagnoseStack != 0 && c.S == 0x00 {
+		c.fail(ErrStackOverflow)
+	}
+}
+
+func (c *CPU) checkPull() {
+	if c.Diagnose&DiagnoseStack != 0 && c.S == 0xFF {
+		c.fail(ErrStackUnderflow)
+	}
+}
+
+// checkFetch runs before an opcode is fetched.
+func (c *CPU) checkFetch() {
+	if c.Diagnose&DiagnoseRunaway == 0 {
+		return
+	}
+	if m := c.Bus.lookup(c.PC); m == nil {
+		c.fail(ErrRunawayNoMemory)
+	} else if _, ok := m.device.(memory); !ok {
+		c.fail(ErrRunawayNoMemory)
+	}
+}
+
+// checkRunaway runs on BRK.
+func (c *CPU) checkRunaway() {
+	if c.Diagnose&DiagnoseRunaway != 0 &&
+		c.Bus.Read(c.instructionPC+1) == 0x00 && c.Bus.Read(c.instructionPC+2) == 0x00 {
+		c.fail(ErrRunaway)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		program []byte
+		want    error
+	}{
+		// LDX #$00, TXS, PHA
+		{"stack overflow", []byte{0xA2, 0x00, 0x9A, 0x48, 0x48}, ErrStackOverflow},
+		// LDX #$FF, TXS, PLA
+		{"stack underflow", []byte{0xA2, 0xFF, 0x9A, 0x68}, ErrStackUnderflow},
+		// RTS with nothing called
+		{"unmatched return", []byte{0x60}, ErrUnmatchedReturn},
+		// JMP $0300 into zeroed RAM
+		{"zero-filled memory", []byte{0x4C, 0x00, 0x03}, ErrRunaway},
+		// JMP $2000, the console
+		{"I/O register", []byte{0x4C, 0x00, 0x20}, ErrRunawayNoMemory},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testCPU(t, tt.program...)
+			c.Diagnose = DiagnoseAll
+			err := c.Run(func() bool { return c.Cycles > 1000 })
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Run returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsOff(t *testing.T) {
+	// PLA with S at $FF, then NOP
+	c := testCPU(t, 0xA2, 0xFF, 0x9A, 0x68, 0xEA)
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Errorf("Run returned %v, want ErrHalted", err)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	if d, err := ParseDiagnostics("stack, runaway"); err != nil || d != DiagnoseStack|DiagnoseRunaway {
+		t.Errorf("ParseDiagnostics = %v, %v", d, err)
+	}
+	if _, err := ParseDiagnostics("stack,everything"); err == nil {
+		t.Error("ParseDiagnostics accepted an unknown check")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Disassemble returns the instruction at address in assembler syntax and
+// its length in bytes. read gives the bytes of memory; opcodes the CPU
+// does not run come out as .BYTE.
+func Disassemble(address uint16, read func(uint16) byte) (string, int) {
+	opcode := read(address)
+	mnemonic, mode := opcodeMode(opcode)
+	if mnemonic == "" {
+		return fmt.Sprintf(".BYTE $%02X", opcode), 1
+	}
+
+	low := read(address + 1)
+	word := uint16(low) | uint16(read(address+2))<<8
+
+	switch mode {
+	case "implied":
+		return mnemonic, 1
+	case "accumulator":
+		return mnemonic + " A", 1
+	case "immediate":
+		return fmt.Sprintf("%s #$%02X", mnemonic, low), 2
+	case "zeroPage":
+		return fmt.Sprintf("%s $%02X", mnemonic, low), 2
+	case "zeroPageX":
+		return fmt.Sprintf("%s $%02X,X", mnemonic, low), 2
+	case "zeroPageY":
+		return fmt.Sprintf("%s $%02X,Y", mnemonic, low), 2
+	case "indirectX":
+		return fmt.Sprintf("%s ($%02X,X)", mnemonic, low), 2
+	case "indirectY":
+		return fmt.Sprintf("%s ($%02X),Y", mnemonic, low), 2
+	case "relative":
+		return fmt.Sprintf("%s $%04X", mnemonic, address+2+uint16(int8(low))), 2
+	case "absolute":
+		return fmt.Sprintf("%s $%04X", mnemonic, word), 3
+	case "absoluteX":
+		return fmt.Sprintf("%s $%04X,X", mnemonic, word), 3
+	case "absoluteY":
+		return fmt.Sprintf("%s $%04X,Y", mnemonic, word), 3
+	case "indirect":
+		return fmt.Sprintf("%s ($%04X)", mnemonic, word), 3
+	}
+	return mnemonic, 1
+}
+
+// opcodeMode returns the mnemonic and addressing mode of an opcode, from
+// titles such as "LDA (absoluteX)", or "" for opcodes the CPU does not run.
+func opcodeMode(opcode byte) (string, string) {
+	mnemonic, mode, _ := strings.Cut(opcodes[opcode].Title, " ")
+	return mnemonic, strings.Trim(mode, "()")
+}
+
+// instructionLength is the length in bytes of the instruction an opcode
+// starts, 1 for opcodes the CPU does not run.
+func instructionLength(opcode byte) int {
+	switch _, mode := opcodeMode(opcode); mode {
+	case "immediate", "zeroPage", "zeroPageX", "zeroPageY", "indirectX", "indirectY", "relative":
+		return 2
+	case "absolute", "absoluteX", "absoluteY", "indirect":
+		return 3
+	}
+	return 1
+}
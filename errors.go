@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrHalted is what Step returns once the program has ended the way the
+// machine ends programs, such as NOP in the sandbox or a trap.
+var ErrHalted = errors.New("program halted")
+
+// ErrInvalidOpcode is what went wrong in a CPUError for an opcode the
+// CPU does not run.
+var ErrInvalidOpcode = errors.New("invalid opcode")
+
+// CPUError stops a program that went wrong. Err says how, the rest is
+// the CPU as the instruction at PC left it.
+type CPUError struct {
+	Err     error
+	PC      uint16
+	Opcode  byte
+	A, X, Y byte
+	S, P    byte
+	Cycles  uint64
+	// Trace is the instructions run up to and including the one at PC,
+	// oldest first
+	Trace []TraceEntry
+	// Calls is the call stack, the innermost call first
+	Calls []CallFrame
+}
+
+func (e *CPUError) Error() string {
+	var fault *MemoryFault
+	switch {
+	case errors.As(e.Err, &fault):
+		return fault.Error()
+	case errors.Is(e.Err, ErrInvalidOpcode):
+		return fmt.Sprintf("%v $%02X at $%04X", e.Err, e.Opcode, e.PC)
+	}
+	return fmt.Sprintf("%v at $%04X", e.Err, e.PC)
+}
+
+func (e *CPUError) Unwrap() error {
+	return e.Err
+}
+
+// Report writes the error with the registers, the call stack and the last
+// instructions, disassembled from memory as it is now.
+func (e *CPUError) Report(w io.Writer) {
+	fmt.Fprintf(w, "Program crashed: %v\n", e)
+	fmt.Fprintf(w, "PC=$%04X A=$%02X X=$%02X Y=$%02X S=$%02X P=%s cycle %d\n",
+		e.PC, e.A, e.X, e.Y, e.S, flagString(e.P), e.Cycles)
+
+	fmt.Fprintln(w, "Call stack:")
+	writeBacktrace(w, e.PC, e.Calls)
+
+	if len(e.Trace) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Last instructions:")
+	for _, t := range e.Trace {
+		text, _ := Disassemble(t.PC, cpu.Bus.Read)
+		line := fmt.Sprintf("  $%04X  %-14s A=$%02X X=$%02X Y=$%02X S=$%02X P=%s  %s",
+			t.PC, text, t.A, t.X, t.Y, t.S, flagString(t.P), cpu.Symbols.Name(t.PC))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+// flagString shows the status register as NV-BDIZC, capitals for the
+// flags set.
+func flagString(p byte) string {
+	const names = "nv-bdizc"
+	flags := []byte(names)
+	for i := range flags {
+		if p&(0x80>>i) != 0 && flags[i] != '-' {
+			flags[i] -= 'a' - 'A'
+		}
+	}
+	return string(flags)
+}
+
+// TraceEntry is an instruction the CPU ran, with the registers before it.
+type TraceEntry struct {
+	PC      uint16
+	Opcode  byte
+	A, X, Y byte
+	S, P    byte
+}
+
+const traceSize = 32
+
+// record adds the instruction being started to the trace.
+func (c *CPU) record(opcode byte) {
+	c.trace[c.traceNext] = TraceEntry{PC: c.instructionPC, Opcode: opcode, A: c.A, X: c.X, Y: c.Y, S: c.S, P: c.P}
+	c.traceNext = (c.traceNext + 1) % traceSize
+	c.traceLen = min(c.traceLen+1, traceSize)
+}
+
+// Trace returns the last instructions run, oldest first.
+func (c *CPU) Trace() []TraceEntry {
+	trace := make([]TraceEntry, 0, c.traceLen)
+	for i := traceSize - c.traceLen; i < traceSize; i++ {
+		trace = append(trace, c.trace[(c.traceNext+i)%traceSize])
+	}
+	return trace
+}
+
+// fail stops the program with err, unless it has already stopped.
+func (c *CPU) fail(err error) {
+	if c.err != nil {
+		return
+	}
+
+	opcode := byte(0)
+	if c.traceLen > 0 {
+		opcode = c.trace[(c.traceNext+traceSize-1)%traceSize].Opcode
+	}
+	c.err = &CPUError{
+		Err: err, PC: c.instructionPC, Opcode: opcode,
+		A: c.A, X: c.X, Y: c.Y, S: c.S, P: c.P,
+		Cycles: c.Cycles,
+		Trace:  c.Trace(),
+		Calls:  c.CallStack(),
+	}
+}
@@ -1,4 +1,5 @@
 .DEFINE EOL $0A
+.DEFINE InputBuffer $0200
 .SEGMENT "RESET"
 .WORD $8000
 
@@ -53,5 +54,3 @@ HelloMessage:
 .BYTE "Hello, What is your name?", EOL
 Welcome:
 .BYTE "Welcome dear ", EOL
-InputBuffer:
-.RES 128
@@ -0,0 +1,58 @@
+.DEFINE TERM $2010
+.DEFINE Left 4
+.DEFINE Right 19
+.DEFINE Top 2
+.DEFINE Bottom 10
+.SEGMENT "RESET"
+.WORD $8000
+
+
+.SEGMENT "CODE"
+LDA #$01
+STA TERM+6
+LDA #$0B
+STA TERM+3
+LDA #$04
+STA TERM+4
+
+LDX #Left
+DrawTopBottom:
+    STX TERM+1
+    LDA #Top
+    STA TERM+2
+    LDA Symbol
+    STA TERM
+    STX TERM+1
+    LDA #Bottom
+    STA TERM+2
+    LDA Symbol
+    STA TERM
+    INX
+    CPX #Right+1
+    BNE DrawTopBottom
+
+LDY #Top+1
+DrawSides:
+    STY TERM+2
+    LDA #Left
+    STA TERM+1
+    LDA Symbol
+    STA TERM
+    LDA #Right
+    STA TERM+1
+    LDA Symbol
+    STA TERM
+    INY
+    CPY #Bottom
+    BNE DrawSides
+
+LDA #$08
+STA TERM+6
+LDA #$00
+STA TERM+1
+LDA #Bottom+2
+STA TERM+2
+NOP
+
+Symbol:
+.BYTE "#"
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FaultPolicy says what a machine does about a bad memory access: a write
+// to ROM, or a read or write where nothing is attached. Real hardware
+// ignores them; while developing a program it helps to hear about them.
+type FaultPolicy int
+
+const (
+	FaultIgnore FaultPolicy = iota
+	FaultLog
+	FaultHalt
+)
+
+var faultPolicies = map[string]FaultPolicy{
+	"ignore": FaultIgnore,
+	"log":    FaultLog,
+	"halt":   FaultHalt,
+}
+
+func ParseFaultPolicy(s string) (FaultPolicy, error) {
+	if policy, ok := faultPolicies[s]; ok {
+		return policy, nil
+	}
+
+	names := make([]string, 0, len(faultPolicies))
+	for name := range faultPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown fault policy %q, choose one of %s", s, strings.Join(names, ", "))
+}
+
+// kinds of bad access
+const (
+	faultROM      = "write to ROM"
+	faultUnmapped = "unmapped"
+)
+
+// MemoryFault is a bad memory access and the instruction that made it.
+type MemoryFault struct {
+	Kind    string
+	Address uint16
+	Value   byte
+	Write   bool
+	// PC is where the instruction starts, Op is nil if the access was the
+	// opcode fetch itself. Sequence is "reset", "IRQ" or "NMI" for an
+	// access by one of those sequences instead.
+	PC       uint16
+	Op       *Opcode
+	Sequence string
+}
+
+func (f *MemoryFault) Error() string {
+	var access string
+	switch {
+	case f.Kind == faultROM:
+		access = fmt.Sprintf("write of $%02X to ROM at $%04X", f.Value, f.Address)
+	case f.Write:
+		access = fmt.Sprintf("write of $%02X to unmapped $%04X", f.Value, f.Address)
+	default:
+		access = fmt.Sprintf("read of unmapped $%04X", f.Address)
+	}
+
+	if f.Sequence == "reset" {
+		return fmt.Sprintf("%s in the reset sequence", access)
+	}
+	if f.Sequence != "" {
+		return fmt.Sprintf("%s in the %s sequence at $%04X", access, f.Sequence, f.PC)
+	}
+	if f.Op == nil {
+		return fmt.Sprintf("%s fetching the opcode at $%04X", access, f.PC)
+	}
+	return fmt.Sprintf("%s by %s at $%04X", access, f.Op.Title, f.PC)
+}
+
+// fault applies the policy to a bad access by the running instruction.
+// Dummy accesses are left alone, hardware makes them wherever the
+// addressing mode happens to point.
+func (c *CPU) fault(kind string, address uint16, value byte, write bool) {
+	if c.Faults == FaultIgnore || c.dummy || c.err != nil {
+		return
+	}
+
+	f := &MemoryFault{Kind: kind, Address: address, Value: value, Write: write, PC: c.instructionPC, Op: c.op}
+	if c.sequence != "" {
+		f.PC, f.Op, f.Sequence = c.PC, nil, c.sequence
+	}
+	if c.Faults == FaultLog {
+		fmt.Fprintf(os.Stderr, "%s\r\n", f)
+		return
+	}
+	c.fail(f)
+}
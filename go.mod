@@ -0,0 +1,3 @@
+module github.com/mega8bit/6502_cpu_emulator
+
+go 1.25
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// HostFS gives programs files in a host directory, and nothing outside
+// it. A command works on one of eight channels, moving data straight to
+// and from memory:
+//
+//	$00-$01 file name pointer, the name ends with a zero byte
+//	$02     mode for open: 0 read, 1 write (create or truncate), 2 append,
+//	        3 read and write
+//	$03-$04 buffer pointer
+//	$05-$06 length to read or write, then the count moved
+//	$07     channel 0-7
+//	$08-$0B seek offset, signed, then the position after the seek
+//	$0C     seek from: 0 start, 1 current position, 2 end
+//	$0D     write: command, see hostFSOpen and the rest
+//	$0E     status of the last command, see hostFSOK and the rest
+//
+// Names are relative to the directory, and ones reaching outside it are
+// refused. A directory listing opens the directory named, "" for the
+// top, as text to read: one name per line, with a / after
+// subdirectories. Commands finish at once; the program only has to check
+// the status.
+type HostFS struct {
+	Root *os.Root
+
+	regs     [13]byte
+	status   byte
+	channels [8]io.ReadWriteSeeker
+}
+
+const (
+	hostFSName = iota
+	_
+	hostFSMode
+	hostFSBuffer
+	_
+	hostFSLength
+	_
+	hostFSChannel
+	hostFSOffset
+	_
+	_
+	_
+	hostFSWhence
+	hostFSCommand
+	hostFSStatus
+)
+
+const (
+	hostFSOpen = iota + 1
+	hostFSRead
+	hostFSWrite
+	hostFSSeek
+	hostFSClose
+	hostFSList
+)
+
+const (
+	hostFSOK = iota
+	hostFSNotFound
+	hostFSDenied
+	hostFSBadChannel
+	hostFSEndOfFile
+	hostFSError
+	hostFSBadCommand
+)
+
+// open modes
+var hostFSFlags = [4]int{
+	os.O_RDONLY,
+	os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	os.O_RDWR | os.O_CREATE,
+}
+
+func NewHostFS(dir string) (*HostFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HostFS{Root: root}, nil
+}
+
+func (h *HostFS) Read(address uint16) byte {
+	switch {
+	case address == hostFSStatus:
+		return h.status
+	case address < hostFSCommand:
+		return h.regs[address]
+	}
+	return 0
+}
+
+func (h *HostFS) Write(address uint16, value byte) {
+	switch {
+	case address == hostFSCommand:
+		h.status = h.command(value)
+	case address < hostFSCommand:
+		h.regs[address] = value
+	}
+}
+
+func (h *HostFS) word(register int) uint16 {
+	return uint16(h.regs[register]) | uint16(h.regs[register+1])<<8
+}
+
+func (h *HostFS) setWord(register int, value uint16) {
+	h.regs[register], h.regs[register+1] = byte(value), byte(value>>8)
+}
+
+// name reads the zero-terminated file name from memory.
+func (h *HostFS) name() string {
+	var name []byte
+	for address := h.word(hostFSName); len(name) < 256; address++ {
+		b := cpu.Bus.Read(address)
+		if b == 0 {
+			break
+		}
+		name = append(name, b)
+	}
+	return string(name)
+}
+
+func (h *HostFS) command(command byte) byte {
+	channel := h.regs[hostFSChannel]
+	if int(channel) >= len(h.channels) {
+		return hostFSBadChannel
+	}
+
+	switch command {
+	case hostFSOpen:
+		return h.open(channel)
+	case hostFSList:
+		return h.list(channel)
+	case hostFSRead, hostFSWrite, hostFSSeek, hostFSClose:
+		if h.channels[channel] == nil {
+			return hostFSBadChannel
+		}
+	default:
+		return hostFSBadCommand
+	}
+
+	switch command {
+	case hostFSRead:
+		return h.read(channel)
+	case hostFSWrite:
+		return h.write(channel)
+	case hostFSSeek:
+		return h.seek(channel)
+	}
+	return h.close(channel)
+}
+
+func (h *HostFS) open(channel byte) byte {
+	mode := h.regs[hostFSMode]
+	if int(mode) >= len(hostFSFlags) {
+		return hostFSBadCommand
+	}
+
+	name := h.name()
+	if !filepath.IsLocal(name) {
+		return hostFSDenied
+	}
+
+	// a failed open leaves the file already on the channel open
+	f, err := h.Root.OpenFile(name, hostFSFlags[mode], 0o644)
+	if err != nil {
+		return hostFSErrorStatus(err)
+	}
+	h.close(channel)
+	h.channels[channel] = f
+	return hostFSOK
+}
+
+func (h *HostFS) list(channel byte) byte {
+	name := h.name()
+	if name == "" {
+		name = "."
+	}
+	if !filepath.IsLocal(name) {
+		return hostFSDenied
+	}
+
+	dir, err := h.Root.Open(name)
+	if err != nil {
+		return hostFSErrorStatus(err)
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return hostFSErrorStatus(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var listing bytes.Buffer
+	for _, entry := range entries {
+		listing.WriteString(entry.Name())
+		if entry.IsDir() {
+			listing.WriteByte('/')
+		}
+		listing.WriteByte('\n')
+	}
+
+	h.close(channel)
+	h.channels[channel] = &hostFSListing{bytes.NewReader(listing.Bytes())}
+	return hostFSOK
+}
+
+func (h *HostFS) read(channel byte) byte {
+	buf := make([]byte, h.word(hostFSLength))
+	n, err := io.ReadFull(h.channels[channel], buf)
+	address := h.word(hostFSBuffer)
+	for _, b := range buf[:n] {
+		cpu.Bus.Write(address, b)
+		address++
+	}
+
+	h.setWord(hostFSLength, uint16(n))
+	switch {
+	case err == nil:
+		return hostFSOK
+	case n == 0 || errors.Is(err, io.ErrUnexpectedEOF):
+		return hostFSEndOfFile
+	}
+	return hostFSErrorStatus(err)
+}
+
+func (h *HostFS) write(channel byte) byte {
+	buf := make([]byte, h.word(hostFSLength))
+	address := h.word(hostFSBuffer)
+	for i := range buf {
+		buf[i] = cpu.Bus.Read(address)
+		address++
+	}
+
+	n, err := h.channels[channel].Write(buf)
+	h.setWord(hostFSLength, uint16(n))
+	if err != nil {
+		return hostFSErrorStatus(err)
+	}
+	return hostFSOK
+}
+
+func (h *HostFS) seek(channel byte) byte {
+	offset := int32(uint32(h.regs[hostFSOffset]) | uint32(h.regs[hostFSOffset+1])<<8 |
+		uint32(h.regs[hostFSOffset+2])<<16 | uint32(h.regs[hostFSOffset+3])<<24)
+	whence := h.regs[hostFSWhence]
+	if whence > io.SeekEnd {
+		return hostFSBadCommand
+	}
+
+	position, err := h.channels[channel].Seek(int64(offset), int(whence))
+	if err != nil {
+		return hostFSErrorStatus(err)
+	}
+	for i := 0; i < 4; i++ {
+		h.regs[hostFSOffset+i] = byte(position >> (8 * i))
+	}
+	return hostFSOK
+}
+
+func (h *HostFS) close(channel byte) byte {
+	f := h.channels[channel]
+	h.channels[channel] = nil
+	if closer, ok := f.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return hostFSErrorStatus(err)
+		}
+	}
+	return hostFSOK
+}
+
+func hostFSErrorStatus(err error) byte {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return hostFSNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return hostFSDenied
+	case errors.Is(err, io.EOF):
+		return hostFSEndOfFile
+	}
+	return hostFSError
+}
+
+// hostFSListing is a directory listing open on a channel, read only.
+type hostFSListing struct {
+	*bytes.Reader
+}
+
+func (hostFSListing) Write([]byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+// Close closes the files programs left open.
+func (h *HostFS) Close() error {
+	for channel := range h.channels {
+		h.close(byte(channel))
+	}
+	return h.Root.Close()
+}
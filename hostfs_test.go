@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runHostFS sets the name and the registers, then runs a command.
+func runHostFS(h *HostFS, command byte, name string, regs map[int]uint16) byte {
+	copy(cpu.Ram[0x200:], name+"\x00")
+	h.setWord(hostFSName, 0x0200)
+	for register, value := range regs {
+		if register == hostFSMode || register == hostFSChannel {
+			h.Write(uint16(register), byte(value))
+			continue
+		}
+		h.setWord(register, value)
+	}
+	h.Write(hostFSCommand, command)
+	return h.Read(hostFSStatus)
+}
+
+func TestHostFSFailedOpenKeepsChannel(t *testing.T) {
+	testCPU(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHostFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if status := runHostFS(h, hostFSOpen, "a.txt", map[int]uint16{hostFSChannel: 1}); status != hostFSOK {
+		t.Fatalf("open: status %d", status)
+	}
+	if status := runHostFS(h, hostFSOpen, "missing.txt", nil); status != hostFSNotFound {
+		t.Errorf("open of a missing file: status %d", status)
+	}
+	if status := runHostFS(h, hostFSOpen, "../a.txt", nil); status != hostFSDenied {
+		t.Errorf("open outside the directory: status %d", status)
+	}
+
+	status := runHostFS(h, hostFSRead, "", map[int]uint16{hostFSBuffer: 0x0300, hostFSLength: 5})
+	if got := string(cpu.Ram[0x300:0x305]); status != hostFSOK || got != "hello" {
+		t.Errorf("read %q with status %d after the failed opens", got, status)
+	}
+}
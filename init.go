@@ -6,11 +6,8 @@ func init() {
 	cpu.A = 0x0
 	cpu.X = 0x0
 	cpu.Y = 0x0
-	cpu.Ram = make([]byte, 0x0800)
+	cpu.Frequency = 1e6
 
 	setAsmOpcodes()
-
-	console := new(Console)
-	console.ReadBuf = make([]byte, 0, 1024)
-	cpu.console = *console
+	_ = setupSandbox(cpu)
 }
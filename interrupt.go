@@ -0,0 +1,71 @@
+package main
+
+// IRQLine is one device's output onto the shared IRQ line. The line is
+// wired-OR: the CPU sees an IRQ while any device holds its output active.
+type IRQLine uint32
+
+func (c *CPU) NewIRQLine() IRQLine {
+	line := IRQLine(1) << c.irqDevices
+	c.irqDevices++
+	return line
+}
+
+func (c *CPU) SetIRQ(line IRQLine, active bool) {
+	if active {
+		c.irq |= line
+		return
+	}
+
+	c.irq &^= line
+}
+
+// NMI signals a falling edge on the NMI line. Unlike IRQ it is latched, so
+// it is serviced once even if the source goes away.
+func (c *CPU) NMI() {
+	c.nmiPending = true
+}
+
+func (c *CPU) interruptAsserted() bool {
+	return c.nmiPending || c.irq != 0 && getFlag(FlagI) == 0
+}
+
+// interrupt runs the 7 cycle sequence shared by IRQ and NMI: two discarded
+// opcode fetches, PC and P (with B clear) pushed, then the vector.
+func (c *CPU) interrupt() {
+	vector := uint16(0xFFFE)
+	from := c.PC
+	c.sequence = "IRQ"
+	if c.nmiPending {
+		c.nmiPending = false
+		vector = 0xFFFA
+		c.sequence = "NMI"
+	}
+	defer func() { c.sequence = "" }()
+
+	c.sync = true
+	c.dummyRead(c.PC)
+	c.dummyRead(c.PC)
+	pushStack16(c.PC)
+	pushStack(c.P&0xef | 0x20)
+	setFlag(FlagI, 1)
+	c.PC = read16(vector)
+	c.called(c.sequence, from, c.PC, from)
+}
+
+// reset is the 7 cycle reset sequence: the CPU goes through the motions of
+// an interrupt with writes suppressed, so S ends up 3 lower than it was.
+func (c *CPU) reset() {
+	c.sequence = "reset"
+	defer func() { c.sequence = "" }()
+	c.calls = c.calls[:0]
+	c.Profile.moved()
+	c.sync = true
+	c.dummyRead(c.PC)
+	c.dummyRead(c.PC)
+	for i := 0; i < 3; i++ {
+		c.dummyRead(0x100 | uint16(c.S))
+		c.S--
+	}
+	setFlag(FlagI, 1)
+	c.PC = read16(0xFFFC)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The KIM-1: 1K of RAM and two 6530 RIOTs, with the monitor in their
+// ROMs. Only 13 address lines are decoded, so these 8K repeat through
+// memory and the vectors come from $1FFA.
+//
+//	$0000-$03FF RAM
+//	$1700 6530-003 I/O and timer    $1740 6530-002 I/O and timer
+//	$1780 6530-003 RAM              $17C0 6530-002 RAM
+//	$1800 6530-003 ROM              $1C00 6530-002 ROM
+//
+// The image is the 2K of both ROMs from $1800, or the 6530-002 ROM alone.
+// The RIOTs' interrupt outputs are left unconnected, as on a stock board.
+
+const (
+	kim1RAM    = 0x0400
+	kim1Mirror = 0x2000
+	kim1Baud   = 2400
+	// how long a keypad key is held down, and the gap before the next
+	kim1KeyTime = 0.04
+	// digits lit within one frame are shown, the eye's persistence
+	kim1Frame = 0.02
+)
+
+// KIM1IO is the 6530-002's ports wired as on the board. PB1-PB4 drive a
+// 74145 decoder: outputs 0-2 select the keypad rows, read back active low
+// on PA0-PA6, output 3 goes to PA0 through the TTY jumper and 4-9 light
+// the six digits with segments a-g on PA0-PA6. In TTY mode the serial
+// line comes in on PA7 and goes out on PB0, bit-banged by the monitor.
+type KIM1IO struct {
+	*RIOT
+	Link *SerialLink
+	Out  io.Writer
+	TTY  bool
+
+	// keypad key held down, -1 for none
+	held  int
+	lit   [6]byte
+	shown [6]byte
+	drawn bool
+
+	rxLine bool
+	txLine bool
+	txBusy bool
+
+	restore func()
+}
+
+// keypad key codes as the monitor numbers them, 0-F are the hex keys
+const (
+	kim1KeyAD = iota + 0x10
+	kim1KeyDA
+	kim1KeyPlus
+	kim1KeyGO
+	kim1KeyPC
+)
+
+func setupKIM1(c *CPU) error {
+	return setupKIM(c, false)
+}
+
+func setupKIM1TTY(c *CPU) error {
+	return setupKIM(c, true)
+}
+
+func setupKIM(c *CPU, tty bool) error {
+	if len(c.Rom) != 0x0400 && len(c.Rom) != 0x0800 {
+		return fmt.Errorf("a KIM-1 ROM is 1K or 2K, not %d bytes", len(c.Rom))
+	}
+
+	io002, err := NewKIM1IO(tty)
+	if err != nil {
+		return err
+	}
+	io003 := newRIOT(RIOT6530, 0)
+	ram003 := make(memoryBlock, 64)
+	ram002 := make(memoryBlock, 64)
+
+	c.Ram = make([]byte, kim1RAM)
+	c.Bus = Bus{}
+	c.Sandbox = false
+	for base := 0; base < 0x10000; base += kim1Mirror {
+		err = errors.Join(err,
+			c.Bus.Attach(uint16(base), kim1RAM, ramWindow{}),
+			c.Bus.Attach(uint16(base+0x1700), 64, io003),
+			c.Bus.Attach(uint16(base+0x1740), 64, io002),
+			c.Bus.Attach(uint16(base+0x1780), 64, ram003),
+			c.Bus.Attach(uint16(base+0x17C0), 64, ram002),
+			c.Bus.Attach(uint16(base+0x2000-len(c.Rom)), len(c.Rom), romWindow{}),
+		)
+	}
+	return err
+}
+
+func NewKIM1IO(tty bool) (*KIM1IO, error) {
+	link, err := OpenSerialLink("stdio")
+	if err != nil {
+		return nil, err
+	}
+
+	k := &KIM1IO{
+		RIOT:    newRIOT(RIOT6530, 0),
+		Link:    link,
+		Out:     os.Stdout,
+		TTY:     tty,
+		held:    -1,
+		rxLine:  true,
+		txLine:  true,
+		restore: func() {},
+	}
+	k.InA = k.inA
+	k.OutA = func(byte) { k.latchDigit() }
+	k.OutB = k.outB
+
+	if restore, err := rawInput(os.Stdin.Fd()); err == nil {
+		k.restore = restore
+	}
+
+	if tty {
+		// the monitor times the first character's start bit to find the
+		// baud rate, and expects a rubout for it
+		link.in <- 0x7F
+		cpu.After(k.bitCycles(0, 1), k.receive)
+	} else {
+		cpu.After(k.seconds(kim1KeyTime), k.pollKeys)
+		cpu.After(k.seconds(kim1Frame), k.frame)
+	}
+	return k, nil
+}
+
+func (k *KIM1IO) seconds(s float64) uint64 {
+	return uint64(cpu.Frequency*s) + 1
+}
+
+func (k *KIM1IO) decoder() byte {
+	port := &k.ports[1]
+	return (port.or&port.ddr | ^port.ddr) >> 1 & 0x0F
+}
+
+func (k *KIM1IO) inA() byte {
+	pins := byte(0xFF)
+	switch d := int(k.decoder()); {
+	case d <= 2 && k.held >= 0 && k.held/7 == d:
+		pins &^= 0x40 >> (k.held % 7)
+	case d == 3 && k.TTY:
+		pins &^= 0x01
+	}
+
+	if !k.rxLine {
+		pins &^= 0x80
+	}
+	return pins
+}
+
+func (k *KIM1IO) outB(pins byte) {
+	k.latchDigit()
+
+	level := pins&0x01 != 0
+	if level == k.txLine {
+		return
+	}
+	k.txLine = level
+	if !level && !k.txBusy && k.TTY {
+		k.transmit(cpu.Cycles)
+	}
+}
+
+// Keypad and display
+
+// kim1Keys maps host keys to the keypad's. RS and ST are not on the
+// matrix: they reset the CPU and pull NMI.
+var kim1Keys = map[byte]int{
+	'm': kim1KeyAD, '.': kim1KeyDA, '+': kim1KeyPlus, 'g': kim1KeyGO, 'p': kim1KeyPC,
+}
+
+func (k *KIM1IO) pollKeys(cycle uint64) {
+	cpu.Scheduler.At(cycle+k.seconds(kim1KeyTime), k.pollKeys)
+	if k.held >= 0 {
+		k.held = -1
+		return
+	}
+
+	b, ok := k.Link.Receive()
+	if !ok {
+		return
+	}
+
+	if b >= 'A' && b <= 'Z' {
+		b += 'a' - 'A'
+	}
+	switch {
+	case b >= '0' && b <= '9':
+		k.held = int(b - '0')
+	case b >= 'a' && b <= 'f':
+		k.held = int(b-'a') + 10
+	case b == 'r':
+		cpu.Reset()
+	case b == 's':
+		cpu.NMI()
+	default:
+		if key, ok := kim1Keys[b]; ok {
+			k.held = key
+		}
+	}
+}
+
+func (k *KIM1IO) latchDigit() {
+	if d := k.decoder(); d >= 4 && d <= 9 {
+		port := &k.ports[0]
+		k.lit[d-4] |= port.or & port.ddr & 0x7F
+	}
+}
+
+func (k *KIM1IO) frame(cycle uint64) {
+	cpu.Scheduler.At(cycle+k.seconds(kim1Frame), k.frame)
+	if !k.drawn || k.lit != k.shown {
+		k.draw(k.lit)
+	}
+	k.lit = [6]byte{}
+}
+
+// draw shows the digits as seven segments on three lines, redrawn in
+// place. Segments a-g are bits 0-6.
+func (k *KIM1IO) draw(digits [6]byte) {
+	segment := func(segs byte, bit uint, on string) string {
+		if segs>>bit&0x01 != 0 {
+			return on
+		}
+		return " "
+	}
+
+	var lines [3]string
+	for i, segs := range digits {
+		if i == 4 {
+			for j := range lines {
+				lines[j] += "  "
+			}
+		}
+		lines[0] += " " + segment(segs, 0, "_") + "  "
+		lines[1] += segment(segs, 5, "|") + segment(segs, 6, "_") + segment(segs, 1, "|") + " "
+		lines[2] += segment(segs, 4, "|") + segment(segs, 3, "_") + segment(segs, 2, "|") + " "
+	}
+
+	if k.drawn {
+		fmt.Fprint(k.Out, "\x1b[3A")
+	}
+	fmt.Fprintf(k.Out, "\r%s\n%s\n%s\n", lines[0], lines[1], lines[2])
+	k.shown = digits
+	k.drawn = true
+}
+
+// TTY
+
+// bitCycles is the time from a frame's start to its bit, fractions of a
+// bit included, without rounding errors adding up over the frame.
+func (k *KIM1IO) bitCycles(bit, fraction float64) uint64 {
+	return uint64((bit + fraction) * cpu.Frequency / kim1Baud)
+}
+
+// receive plays the next character from the host onto PA7: a start bit,
+// eight data bits from the lowest and two stop bits.
+func (k *KIM1IO) receive(cycle uint64) {
+	b, ok := k.Link.Receive()
+	if !ok {
+		cpu.Scheduler.At(cycle+k.bitCycles(0, 1), k.receive)
+		return
+	}
+
+	frame := uint16(b)<<1 | 0x600
+	for i := 0; i < 11; i++ {
+		level := frame>>i&0x01 != 0
+		cpu.Scheduler.At(cycle+k.bitCycles(float64(i), 0), func(uint64) { k.rxLine = level })
+	}
+	cpu.Scheduler.At(cycle+k.bitCycles(11, 0), k.receive)
+}
+
+// transmit samples PB0 in the middle of each bit of the frame whose start
+// bit began at cycle. Bit 7 is the teletype's parity and dropped.
+func (k *KIM1IO) transmit(cycle uint64) {
+	k.txBusy = true
+	var value byte
+	for i := 0; i < 8; i++ {
+		bit := byte(1) << i
+		cpu.Scheduler.At(cycle+k.bitCycles(float64(i+1), 0.5), func(uint64) {
+			if k.txLine {
+				value |= bit
+			}
+		})
+	}
+
+	cpu.Scheduler.At(cycle+k.bitCycles(9, 0.5), func(uint64) {
+		k.txBusy = false
+		k.Link.Send(value & 0x7F)
+	})
+}
+
+func (k *KIM1IO) Close() error {
+	k.restore()
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Machine is a system to run program images on: the memory map and the
+// devices that come with it. Devices given on the command line are laid
+// over it.
+type Machine struct {
+	Name        string
+	Description string
+	// Setup builds the machine around the program image in c.Rom
+	Setup func(c *CPU) error
+	// Faults is what the machine does about bad memory accesses unless
+	// told otherwise
+	Faults FaultPolicy
+}
+
+var machines = map[string]*Machine{
+	"sandbox": {
+		Name:        "sandbox",
+		Description: "2K of RAM, the console at $2000 and the program image above it, NOP halts",
+		Setup:       setupSandbox,
+		Faults:      FaultHalt,
+	},
+	"apple1": {
+		Name:        "apple1",
+		Description: "Apple-1: 32K of RAM, keyboard and display PIA at $D010, ROM at $FF00",
+		Setup:       setupApple1,
+	},
+	"kim1": {
+		Name:        "kim1",
+		Description: "KIM-1: 1K of RAM, two 6530 RIOTs, keypad and LED display in the terminal",
+		Setup:       setupKIM1,
+	},
+	"kim1-tty": {
+		Name:        "kim1-tty",
+		Description: "KIM-1 with the TTY jumper in, the monitor talks to the console at 2400 baud",
+		Setup:       setupKIM1TTY,
+	},
+	"c64": {
+		Name:        "c64",
+		Description: "Commodore 64 PRG files with KERNAL calls done on the host, no VIC-II or SID",
+		Setup:       setupC64,
+	},
+}
+
+func findMachine(name string) (*Machine, error) {
+	if m, ok := machines[name]; ok {
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unknown machine %q, choose one of %s", name, strings.Join(machineNames(), ", "))
+}
+
+func machineNames() []string {
+	names := make([]string, 0, len(machines))
+	for name := range machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
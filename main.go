@@ -1,18 +1,76 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
 func main() {
-	if len(os.Args) != 2 {
+	machineName := flag.String("machine", "sandbox", "machine to run the program on: "+strings.Join(machineNames(), ", "))
+	faults := flag.String("faults", "", "on bad memory accesses: ignore, log or halt; the machine decides by default")
+	symbolsPath := flag.String("symbols", "", "label file (ld65 -Ln) or debug file (ld65 --dbgfile) naming addresses in reports")
+	profilePath := flag.String("profile", "", "count instructions and cycles by address and function, and write them to this file on exit")
+	profileFormat := flag.String("profile-format", "text", "profile file format: "+strings.Join(profileFormats, ", "))
+	coveragePath := flag.String("coverage", "", "record the code run and data read, and write a report by source line to this file on exit")
+	coverageFormat := flag.String("coverage-format", "html", "coverage report format: "+strings.Join(coverageFormats, ", "))
+	coverageDebug := flag.String("coverage-dbg", "", "ld65 debug file (--dbgfile) mapping the program to source lines, needed for -coverage")
+	diagnose := flag.String("diagnose", "", "stop on program bugs: stack, returns, runaway, comma separated, or all")
+	busAccurate := flag.Bool("bus-accurate", false, "perform the dummy reads and writes of real hardware")
+	perCycle := flag.Bool("per-cycle", false, "run on the per-cycle core, one bus access per clock")
+	clockSpeed := flag.String("clock", "unlimited", "CPU clock: 1MHz, 1.79MHz, 2MHz, any MHz value or unlimited")
+	turbo := flag.Bool("turbo", false, "start in turbo mode, SIGUSR1 toggles it")
+	stats := flag.Bool("stats", false, "print cycle count and effective speed on exit")
+	viaAddress := flag.String("via", "", "attach a 6522 VIA at this address, e.g. $6000")
+	aciaAddress := flag.String("acia", "", "attach a 6551 ACIA at this address, e.g. $5000")
+	aciaLink := flag.String("acia-link", "stdio", "ACIA serial side: stdio, pty or tcp:host:port")
+	raw := flag.Bool("raw", false, "raw console: single keystrokes at $2000, key status at $2001")
+	terminalAddress := flag.String("terminal", "", "attach an ANSI terminal device at this address, e.g. $2010")
+	videoAddress := flag.String("video", "", "attach a text screen at this address, e.g. $4000")
+	videoSize := flag.String("video-size", "40x25", "text screen columns x rows")
+	videoRate := flag.Float64("video-rate", 60, "text screen refresh rate in Hz")
+	bitmapAddress := flag.String("bitmap", "", "attach a headless bitmap screen at this address, e.g. $8000")
+	bitmapSize := flag.String("bitmap-size", "128x128", "bitmap width x height in pixels")
+	bitmapDepth := flag.Int("bitmap-depth", 4, "bitmap bits per pixel: 1, 2, 4 or 8")
+	bitmapRate := flag.Float64("bitmap-rate", 50, "bitmap vblank rate in Hz")
+	bitmapPNG := flag.String("bitmap-png", "frame%04d.png", "PNG file names, given the frame number")
+	bitmapGIF := flag.String("bitmap-gif", "", "write the collected frames to this animated GIF on exit")
+	fsAddress := flag.String("fs", "", "attach the host file device at this address, e.g. $2100")
+	fsRoot := flag.String("fs-root", ".", "directory the host file device can reach")
+	timerAddress := flag.String("timer", "", "attach an interval timer at this address, e.g. $2300")
+	rtcAddress := flag.String("rtc", "", "attach a real-time clock at this address, e.g. $2310")
+	soundAddress := flag.String("sound", "", "attach a sound generator at this address, e.g. $2320")
+	soundWAV := flag.String("sound-wav", "sound.wav", "WAV file the sound generator writes")
+	soundRate := flag.Int("sound-rate", 44100, "sound sample rate in Hz")
+	mapperScheme := flag.String("mapper", "", "switch banks of a large image into $8000-$FFFF: nrom, uxrom, mmc1, bank16 or bank8")
+	mapperRegs := flag.String("mapper-regs", "$2330", "address of the bank16 and bank8 bank registers")
+	mapperRAM := flag.Int("mapper-ram", 0, "8K RAM banks to switch into $6000-$7FFF")
+	diskAddress := flag.String("disk", "", "attach a block device at this address, e.g. $2200")
+	diskImage := flag.String("disk-image", "disk.img", "disk image file for the block device")
+	diskProtect := flag.Bool("disk-protect", false, "write protect the disk")
+	diskOverlay := flag.String("disk-overlay", "", "keep disk writes out of the image: - in memory, or in this overlay file")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
 		fmt.Println("Wrong input parameters for emulator")
 		return
 	}
 
-	programPath := os.Args[1]
+	hz, err := ParseClock(*clockSpeed)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	programPath := flag.Arg(0)
 	fileData, err := ioutil.ReadFile(programPath)
 
 	if err != nil {
@@ -20,13 +78,358 @@ func main() {
 		return
 	}
 
+	machine, err := findMachine(*machineName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	clock := &Clock{Hz: hz}
+	clock.SetTurbo(*turbo)
+	toggleTurboOnSignal(clock)
+	clock.Start(cpu)
+
 	cpu.Rom = fileData
-	cpu.Reset()
+	if err := machine.Setup(cpu); err != nil {
+		fmt.Println("Cannot set up", machine.Name, err)
+		return
+	}
+	defer cpu.Bus.Close()
+
+	if *symbolsPath != "" {
+		if cpu.Symbols, err = LoadSymbols(*symbolsPath); err != nil {
+			fmt.Println("Cannot read symbols", err)
+			return
+		}
+	}
+
+	if *profilePath != "" {
+		if *profileFormat, err = ParseProfileFormat(*profileFormat); err != nil {
+			fmt.Println(err)
+			return
+		}
+		cpu.Profile = NewProfile()
+	}
+
+	var debugInfo *DebugInfo
+	if *coveragePath != "" {
+		if *coverageFormat, err = ParseCoverageFormat(*coverageFormat); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if *coverageDebug == "" {
+			fmt.Println("Coverage needs the program's debug file, -coverage-dbg")
+			return
+		}
+		if debugInfo, err = LoadDebugInfo(*coverageDebug); err != nil {
+			fmt.Println("Cannot read debug info", err)
+			return
+		}
+		cpu.Coverage = NewCoverage()
+	}
+
+	if *diagnose != "" {
+		if cpu.Diagnose, err = ParseDiagnostics(*diagnose); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	cpu.Faults = machine.Faults
+	if *faults != "" {
+		if cpu.Faults, err = ParseFaultPolicy(*faults); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *viaAddress != "" {
+		base, err := parseAddress(*viaAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(base, 16, NewVIA()) {
+			return
+		}
+	}
+
+	if *aciaAddress != "" {
+		base, err := parseAddress(*aciaAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		link, err := OpenSerialLink(*aciaLink)
+		if err != nil {
+			fmt.Println("Cannot open serial link", err)
+			return
+		}
+		if !attachDevice(base, 4, NewACIA(link)) {
+			return
+		}
+	}
+
+	if *raw {
+		console, err := NewRawConsole()
+		if err != nil {
+			fmt.Println("Cannot use raw console", err)
+			return
+		}
+		if !attachDevice(0x2000, 2, console) {
+			return
+		}
+	}
+
+	if *terminalAddress != "" {
+		base, err := parseAddress(*terminalAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(base, 7, NewTerminal()) {
+			return
+		}
+	}
+
+	if *videoAddress != "" {
+		base, err := parseAddress(*videoAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		columns, rows, err := parseSize(*videoSize)
+		if err != nil || *videoRate <= 0 {
+			fmt.Println("Bad text screen size or rate")
+			return
+		}
+		video, err := NewTextVideo(columns, rows, *videoRate)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(base, video.Size(), video) {
+			return
+		}
+	}
+
+	if *bitmapAddress != "" {
+		base, err := parseAddress(*bitmapAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		width, height, err := parseSize(*bitmapSize)
+		if err != nil || *bitmapRate <= 0 {
+			fmt.Println("Bad bitmap size or rate")
+			return
+		}
+		bitmap, err := NewBitmap(width, height, *bitmapDepth, *bitmapRate)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		bitmap.PNGPattern = *bitmapPNG
+		bitmap.GIFPath = *bitmapGIF
+		if !attachDevice(base, bitmap.Size(), bitmap) {
+			return
+		}
+	}
+
+	if *fsAddress != "" {
+		base, err := parseAddress(*fsAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		files, err := NewHostFS(*fsRoot)
+		if err != nil {
+			fmt.Println("Cannot open host file directory", err)
+			return
+		}
+		if !attachDevice(base, 15, files) {
+			return
+		}
+	}
+
+	if *diskAddress != "" {
+		base, err := parseAddress(*diskAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
-	var isExit bool
-	for !isExit {
-		isExit = cpu.Step()
+		disk, err := OpenBlockDevice(*diskImage, *diskProtect, *diskOverlay)
+		if err != nil {
+			fmt.Println("Cannot open disk image", err)
+			return
+		}
+		if !attachDevice(base, 15, disk) {
+			return
+		}
 	}
 
+	if *timerAddress != "" {
+		base, err := parseAddress(*timerAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(base, 7, NewPIT()) {
+			return
+		}
+	}
+
+	if *rtcAddress != "" {
+		base, err := parseAddress(*rtcAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(base, 8, NewRTC()) {
+			return
+		}
+	}
+
+	if *mapperScheme != "" {
+		mapper, err := NewMapper(*mapperScheme, cpu.Rom, *mapperRAM)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !attachDevice(0x8000, 0x8000, mapper.ROM()) {
+			return
+		}
+		if ram := mapper.RAM(); ram != nil {
+			if !attachDevice(0x6000, mapperRAMBank, ram) {
+				return
+			}
+		}
+		if regs, size := mapper.Registers(); regs != nil {
+			base, err := parseAddress(*mapperRegs)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if !attachDevice(base, size, regs) {
+				return
+			}
+		}
+	}
+
+	if *soundAddress != "" {
+		base, err := parseAddress(*soundAddress)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if *soundRate <= 0 {
+			fmt.Println("Bad sound sample rate")
+			return
+		}
+
+		sound, err := NewSound(*soundWAV, *soundRate)
+		if err != nil {
+			fmt.Println("Cannot create sound file", err)
+			return
+		}
+		if !attachDevice(base, 0x16, sound) {
+			return
+		}
+	}
+
+	cpu.BusAccurate = *busAccurate || *perCycle
+	cpu.PerCycle = *perCycle
+	cpu.Reset()
+
+	interrupted := stopOnInterrupt()
+	err = cpu.Run(interrupted.Load)
+
+	// the crash report, profile and coverage read memory through the
+	// devices, so they are made before the devices close. Messages wait
+	// for the terminal to be restored.
+	var report bytes.Buffer
+	var crash *CPUError
+	if errors.As(err, &crash) {
+		crash.Report(&report)
+	}
+	if cpu.Profile != nil {
+		if err := cpu.Profile.WriteFile(*profilePath, *profileFormat); err != nil {
+			fmt.Fprintln(&report, "Cannot write profile", err)
+		}
+	}
+	if cpu.Coverage != nil {
+		if err := cpu.Coverage.WriteFile(*coveragePath, *coverageFormat, debugInfo); err != nil {
+			fmt.Fprintln(&report, "Cannot write coverage report", err)
+		}
+	}
+	cpu.Bus.Close()
+	os.Stdout.Write(report.Bytes())
 	fmt.Println("Program has been executed")
+	if *stats {
+		fmt.Printf("%d cycles, effective speed %.3f MHz\n", cpu.Cycles, clock.EffectiveMHz())
+	}
+}
+
+// attachDevice attaches a device given on the command line, or says why
+// it cannot and closes it.
+func attachDevice(base uint16, size int, device Device) bool {
+	if err := cpu.Bus.Attach(base, size, device); err != nil {
+		if closer, ok := device.(io.Closer); ok {
+			closer.Close()
+		}
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// parseAddress reads an address written as $hex, 0xhex or decimal.
+func parseAddress(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		s, base = s[2:], 16
+	}
+
+	address, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+
+	return uint16(address), nil
+}
+
+// stopOnInterrupt reports the first Ctrl-C so the run loop can stop and
+// devices get closed. A second one exits straight away, still closing the
+// devices so the terminal is restored.
+func stopOnInterrupt() *atomic.Bool {
+	var interrupted atomic.Bool
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		interrupted.Store(true)
+		<-signals
+		cpu.Bus.Close()
+		os.Exit(1)
+	}()
+	return &interrupted
+}
+
+// parseSize reads a size written as WIDTHxHEIGHT.
+func parseSize(s string) (int, int, error) {
+	var width, height int
+	if _, err := fmt.Sscanf(s, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("bad size %q", s)
+	}
+
+	return width, height, nil
 }
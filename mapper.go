@@ -0,0 +1,241 @@
+package main
+
+import "fmt"
+
+// Mapper switches banks of a program image larger than the 32K from $8000
+// into that space, and banks of RAM into $6000-$7FFF. The image is a
+// list of banks of the scheme's size, the first bank first. At start the
+// windows hold the first banks, except the last window, which holds the
+// last bank so the vectors are there.
+//
+//	nrom   16K or 32K, no switching, a 16K image repeats at $C000
+//	uxrom  16K banks: writing to $8000-$FFFF picks the bank at $8000,
+//	       $C000 keeps the last bank
+//	mmc1   16K banks through the MMC1's serial register, written a bit at
+//	       a time at $8000-$FFFF; 8K of RAM at $6000
+//	bank16 16K banks at $8000 and $C000, picked by registers
+//	bank8  8K banks at $8000, $A000, $C000 and $E000, picked by registers
+//
+// The registers of bank16 and bank8 are a block of their own: a byte per
+// window holding its bank, then the RAM bank. RAM banks are 8K, and
+// any scheme can have them.
+type Mapper struct {
+	Scheme string
+
+	rom   []byte
+	size  int
+	banks []int
+
+	ram        []byte
+	ramBank    int
+	ramEnabled bool
+
+	// MMC1 shift register and registers
+	shift   byte
+	control byte
+	chr0    byte
+	prg     byte
+}
+
+const mapperRAMBank = 0x2000
+
+var mapperBankSizes = map[string]int{
+	"nrom":   0x4000,
+	"uxrom":  0x4000,
+	"mmc1":   0x4000,
+	"bank16": 0x4000,
+	"bank8":  0x2000,
+}
+
+// mapperRAMBanks is the most RAM banks each scheme can pick from: one
+// without a register to switch them, four through the MMC1's two bits and
+// a byte's worth through the bank16 and bank8 registers.
+var mapperRAMBanks = map[string]int{
+	"nrom":   1,
+	"uxrom":  1,
+	"mmc1":   4,
+	"bank16": 256,
+	"bank8":  256,
+}
+
+// NewMapper switches banks of rom. ramBanks is the number of 8K RAM banks,
+// at least one for mmc1.
+func NewMapper(scheme string, rom []byte, ramBanks int) (*Mapper, error) {
+	size, ok := mapperBankSizes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown mapper %q, choose one of nrom, uxrom, mmc1, bank16, bank8", scheme)
+	}
+	if len(rom) == 0 || len(rom)%size != 0 {
+		return nil, fmt.Errorf("a %s image is made of %dK banks, not %d bytes", scheme, size/1024, len(rom))
+	}
+	if scheme == "nrom" && len(rom) > 0x8000 {
+		return nil, fmt.Errorf("an nrom image is 16K or 32K, not %d bytes", len(rom))
+	}
+	if ramBanks < 0 || ramBanks > mapperRAMBanks[scheme] {
+		return nil, fmt.Errorf("%s can switch 0 to %d RAM banks, not %d", scheme, mapperRAMBanks[scheme], ramBanks)
+	}
+	if scheme == "mmc1" && ramBanks == 0 {
+		ramBanks = 1
+	}
+
+	m := &Mapper{
+		Scheme:     scheme,
+		rom:        rom,
+		size:       size,
+		banks:      make([]int, 0x8000/size),
+		ram:        make([]byte, ramBanks*mapperRAMBank),
+		ramEnabled: true,
+		shift:      0x10,
+		control:    0x0C,
+	}
+	for i := range m.banks {
+		m.banks[i] = i % m.count()
+	}
+	m.banks[len(m.banks)-1] = m.count() - 1
+	return m, nil
+}
+
+func (m *Mapper) count() int {
+	return len(m.rom) / m.size
+}
+
+func (m *Mapper) Read(address uint16) byte {
+	window := int(address) / m.size
+	return m.rom[m.banks[window]*m.size+int(address)%m.size]
+}
+
+// Write goes to the mapper's registers in uxrom and mmc1, ROM is not
+// written.
+func (m *Mapper) Write(address uint16, value byte) {
+	switch m.Scheme {
+	case "uxrom":
+		m.banks[0] = int(value) % m.count()
+	case "mmc1":
+		m.writeMMC1(address, value)
+	}
+}
+
+// writeMMC1 shifts in bit 0 of value, lowest bit first. The fifth write
+// goes to the register picked by A13 and A14, and a write with bit 7 set
+// starts over.
+func (m *Mapper) writeMMC1(address uint16, value byte) {
+	if value&0x80 != 0 {
+		m.shift = 0x10
+		m.control |= 0x0C
+		m.updateMMC1()
+		return
+	}
+
+	full := m.shift&0x01 != 0
+	m.shift = m.shift>>1 | (value&0x01)<<4
+	if !full {
+		return
+	}
+
+	switch address >> 13 & 0x03 {
+	case 0:
+		m.control = m.shift
+	case 1:
+		m.chr0 = m.shift
+	case 3:
+		m.prg = m.shift
+	}
+	m.shift = 0x10
+	m.updateMMC1()
+}
+
+// updateMMC1 maps the banks from the control register's PRG mode: 0 and
+// 1 switch 32K at once, 2 fixes the first bank at $8000 and 3 the last one
+// at $C000. SxROM boards pick the RAM bank with CHR bank 0 bits 2-3.
+func (m *Mapper) updateMMC1() {
+	bank := int(m.prg & 0x0F)
+	last := m.count() - 1
+	switch m.control >> 2 & 0x03 {
+	case 0, 1:
+		m.banks[0], m.banks[1] = bank&^1, bank|1
+	case 2:
+		m.banks[0], m.banks[1] = 0, bank
+	case 3:
+		m.banks[0], m.banks[1] = bank, last
+	}
+	for i := range m.banks {
+		m.banks[i] %= m.count()
+	}
+
+	m.ramEnabled = m.prg&0x10 == 0
+	m.ramBank = int(m.chr0>>2&0x03) % (len(m.ram) / mapperRAMBank)
+}
+
+// ROM is the banked window for $8000. Where writes do not reach the
+// mapper's registers it is read-only, so they are faults as with plain
+// ROM.
+func (m *Mapper) ROM() Device {
+	if m.Scheme == "uxrom" || m.Scheme == "mmc1" {
+		return m
+	}
+	return mapperROM{m}
+}
+
+// RAM is the banked RAM window for $6000, nil without RAM.
+func (m *Mapper) RAM() Device {
+	if len(m.ram) == 0 {
+		return nil
+	}
+	return mapperRAM{m}
+}
+
+// Registers are the bank registers of bank16 and bank8, nil for the other
+// schemes.
+func (m *Mapper) Registers() (Device, int) {
+	if m.Scheme != "bank16" && m.Scheme != "bank8" {
+		return nil, 0
+	}
+	return mapperRegisters{m}, len(m.banks) + 1
+}
+
+type mapperROM struct {
+	*Mapper
+}
+
+type mapperRAM struct {
+	m *Mapper
+}
+
+func (*Mapper) memory()   {}
+func (mapperRAM) memory() {}
+
+func (mapperROM) readOnly() {}
+
+func (r mapperRAM) Read(address uint16) byte {
+	if !r.m.ramEnabled {
+		return 0
+	}
+	return r.m.ram[r.m.ramBank*mapperRAMBank+int(address)]
+}
+
+func (r mapperRAM) Write(address uint16, value byte) {
+	if r.m.ramEnabled {
+		r.m.ram[r.m.ramBank*mapperRAMBank+int(address)] = value
+	}
+}
+
+type mapperRegisters struct {
+	m *Mapper
+}
+
+func (r mapperRegisters) Read(address uint16) byte {
+	if int(address) < len(r.m.banks) {
+		return byte(r.m.banks[address])
+	}
+	return byte(r.m.ramBank)
+}
+
+func (r mapperRegisters) Write(address uint16, value byte) {
+	if int(address) < len(r.m.banks) {
+		r.m.banks[address] = int(value) % r.m.count()
+		return
+	}
+	if banks := len(r.m.ram) / mapperRAMBank; banks > 0 {
+		r.m.ramBank = int(value) % banks
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMapperRAMBanks(t *testing.T) {
+	rom := make([]byte, 0x10000)
+	for _, tt := range []struct {
+		scheme string
+		banks  int
+		ok     bool
+	}{
+		{"bank16", 0, true},
+		{"bank16", 256, true},
+		{"bank16", 257, false},
+		{"bank8", -1, false},
+		{"mmc1", 4, true},
+		{"mmc1", 5, false},
+		{"uxrom", 1, true},
+		{"uxrom", 2, false},
+	} {
+		_, err := NewMapper(tt.scheme, rom, tt.banks)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewMapper(%s, %d RAM banks): %v", tt.scheme, tt.banks, err)
+		}
+	}
+}
+
+func TestMapperBank16Switching(t *testing.T) {
+	rom := make([]byte, 4*0x4000)
+	for bank := 0; bank < 4; bank++ {
+		rom[bank*0x4000] = byte(bank)
+	}
+	m, err := NewMapper("bank16", rom, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Read(0x0000) != 0 || m.Read(0x4000) != 3 {
+		t.Fatalf("start with banks %d and %d", m.Read(0x0000), m.Read(0x4000))
+	}
+
+	regs, size := m.Registers()
+	if size != 3 {
+		t.Fatalf("%d registers", size)
+	}
+	regs.Write(0, 2)
+	if m.Read(0x0000) != 2 {
+		t.Errorf("window 0 holds bank %d after picking bank 2", m.Read(0x0000))
+	}
+
+	ram := m.RAM()
+	ram.Write(0x0010, 0xAA)
+	regs.Write(2, 1)
+	if ram.Read(0x0010) == 0xAA {
+		t.Error("RAM bank 1 shows what was written to bank 0")
+	}
+	regs.Write(2, 0)
+	if ram.Read(0x0010) != 0xAA {
+		t.Error("RAM bank 0 lost its byte")
+	}
+}
+
+func TestMapperROMWrites(t *testing.T) {
+	// LDA #$00, STA $8000, NOP
+	rom := make([]byte, 0x8000)
+	copy(rom, []byte{0xA9, 0x00, 0x8D, 0x00, 0x80, 0xEA})
+	rom[0x7FFC], rom[0x7FFD] = 0x00, 0x80
+
+	for scheme, registers := range map[string]bool{
+		"nrom": false, "uxrom": true, "mmc1": true, "bank16": false, "bank8": false,
+	} {
+		c := testCPU(t)
+		m, err := NewMapper(scheme, rom, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Bus.Attach(0x8000, 0x8000, m.ROM()); err != nil {
+			t.Fatal(err)
+		}
+		c.Reset()
+
+		err = c.Run(func() bool { return false })
+		var fault *MemoryFault
+		switch {
+		case registers && err != ErrHalted:
+			t.Errorf("%s: a register write returned %v", scheme, err)
+		case !registers && (!errors.As(err, &fault) || fault.Kind != faultROM):
+			t.Errorf("%s: a write to ROM returned %v, want a fault", scheme, err)
+		}
+	}
+}
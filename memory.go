@@ -0,0 +1,74 @@
+package main
+
+import "errors"
+
+// The sandbox machine: Ram from $0000, repeating up to $1FFF, the console
+// at $2000 and the program image answering everywhere above, mirrored
+// every 32K. Past the end of a shorter image nothing is attached.
+
+// ramWindow is Ram, repeating every len(Ram) bytes when attached over a
+// bigger range.
+type ramWindow struct{}
+
+func (ramWindow) Read(address uint16) byte {
+	return cpu.Ram[int(address)%len(cpu.Ram)]
+}
+
+func (ramWindow) Write(address uint16, value byte) {
+	cpu.Ram[int(address)%len(cpu.Ram)] = value
+}
+
+type romWindow struct {
+	base uint16
+}
+
+func (w romWindow) Read(address uint16) byte {
+	return cpu.Rom[(w.base+address)%0x8000]
+}
+
+// Write does nothing, the CPU's writes are faults and never get here.
+func (w romWindow) Write(address uint16, value byte) {}
+
+func (romWindow) readOnly() {}
+
+func (ramWindow) memory()   {}
+func (romWindow) memory()   {}
+func (memoryBlock) memory() {}
+
+func setupSandbox(c *CPU) error {
+	console := new(Console)
+	console.ReadBuf = make([]byte, 0, 1024)
+
+	c.Ram = make([]byte, 0x0800)
+	c.Bus = Bus{}
+	c.Sandbox = true
+	return attachSandbox(&c.Bus, console)
+}
+
+func attachSandbox(bus *Bus, console Device) error {
+	err := errors.Join(
+		bus.Attach(0x0000, 0x2000, ramWindow{}),
+		bus.Attach(0x2000, 1, console),
+	)
+
+	size := min(len(cpu.Rom), 0x8000)
+	for _, base := range []int{0x0000, 0x8000} {
+		start := max(base, 0x2001)
+		if end := base + size; end > start {
+			err = errors.Join(err, bus.Attach(uint16(start), end-start, romWindow{base: uint16(start % 0x8000)}))
+		}
+	}
+	return err
+}
+
+// memoryBlock is RAM outside cpu.Ram, such as the few bytes inside a
+// peripheral chip.
+type memoryBlock []byte
+
+func (m memoryBlock) Read(address uint16) byte {
+	return m[address]
+}
+
+func (m memoryBlock) Write(address uint16, value byte) {
+	m[address] = value
+}
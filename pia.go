@@ -0,0 +1,197 @@
+package main
+
+// PIA is a 6821 Peripheral Interface Adapter: two 8-bit ports, each with
+// a data direction register, a control register and two control lines.
+//
+//	$00 port A, or DDRA when CRA bit 2 is clear
+//	$01 CRA
+//	$02 port B, or DDRB when CRB bit 2 is clear
+//	$03 CRB
+//
+// In a control register bit 0 enables the C1 interrupt, bit 1 picks its
+// active edge (set for rising), bit 2 selects the port over the DDR and
+// bits 3-5 set up C2. Bits 7 and 6 are the C1 and C2 flags, cleared by
+// reading the port.
+type PIA struct {
+	// InA and InB give the levels on the port pins the PIA does not drive,
+	// unconnected pins read high
+	InA func() byte
+	InB func() byte
+	// OutA and OutB are called with the port pins after the output or
+	// direction register changes, inputs float high
+	OutA func(pins byte)
+	OutB func(pins byte)
+	// CA2Out and CB2Out follow the control lines in output modes
+	CA2Out func(level bool)
+	CB2Out func(level bool)
+
+	ports [2]piaPort
+	// IRQA and IRQB are wired together, a zero line leaves them
+	// unconnected
+	irq IRQLine
+}
+
+type piaPort struct {
+	or, ddr, cr byte
+	c1, c2      bool
+}
+
+// control register flags
+const (
+	piaIRQ2 = 0x40
+	piaIRQ1 = 0x80
+)
+
+func NewPIA() *PIA {
+	return newPIA(cpu.NewIRQLine())
+}
+
+func newPIA(irq IRQLine) *PIA {
+	p := &PIA{irq: irq}
+	p.ports[0].c2, p.ports[1].c2 = true, true
+	return p
+}
+
+func (p *PIA) Read(address uint16) byte {
+	side := int(address>>1) & 0x01
+	port := &p.ports[side]
+	if address&0x01 != 0 {
+		return port.cr
+	}
+
+	if port.cr&0x04 == 0 {
+		return port.ddr
+	}
+
+	port.cr &^= piaIRQ1 | piaIRQ2
+	p.updateIRQ()
+	if side == 0 {
+		p.handshake(side)
+	}
+	return port.or&port.ddr | p.pinsIn(side)&^port.ddr
+}
+
+func (p *PIA) Write(address uint16, value byte) {
+	side := int(address>>1) & 0x01
+	port := &p.ports[side]
+	if address&0x01 != 0 {
+		port.cr = port.cr&(piaIRQ1|piaIRQ2) | value&0x3F
+		if port.cr&0x20 != 0 {
+			// an output now, so no input flag
+			port.cr &^= piaIRQ2
+		}
+		p.controlOutput(side)
+		p.updateIRQ()
+		return
+	}
+
+	if port.cr&0x04 == 0 {
+		port.ddr = value
+	} else {
+		port.or = value
+		if side == 1 {
+			p.handshake(side)
+		}
+	}
+	p.output(side)
+}
+
+func (p *PIA) pinsIn(side int) byte {
+	in := p.InA
+	if side == 1 {
+		in = p.InB
+	}
+	if in == nil {
+		return 0xFF
+	}
+	return in()
+}
+
+func (p *PIA) output(side int) {
+	out := p.OutA
+	if side == 1 {
+		out = p.OutB
+	}
+	if out != nil {
+		port := &p.ports[side]
+		out(port.or&port.ddr | ^port.ddr)
+	}
+}
+
+func (p *PIA) updateIRQ() {
+	active := false
+	for _, port := range p.ports {
+		if port.cr&piaIRQ1 != 0 && port.cr&0x01 != 0 ||
+			port.cr&piaIRQ2 != 0 && port.cr&0x28 == 0x08 {
+			active = true
+		}
+	}
+	cpu.SetIRQ(p.irq, active)
+}
+
+// Control lines
+
+func (p *PIA) SetCA1(level bool) { p.setC1(0, level) }
+func (p *PIA) SetCA2(level bool) { p.setC2In(0, level) }
+func (p *PIA) SetCB1(level bool) { p.setC1(1, level) }
+func (p *PIA) SetCB2(level bool) { p.setC2In(1, level) }
+
+func (p *PIA) setC1(side int, level bool) {
+	port := &p.ports[side]
+	if level != port.c1 && level == (port.cr&0x02 != 0) {
+		port.cr |= piaIRQ1
+		p.updateIRQ()
+		if port.cr&0x38 == 0x20 {
+			p.setC2(side, true)
+		}
+	}
+	port.c1 = level
+}
+
+func (p *PIA) setC2In(side int, level bool) {
+	port := &p.ports[side]
+	if port.cr&0x20 == 0 && level != port.c2 && level == (port.cr&0x10 != 0) {
+		port.cr |= piaIRQ2
+		p.updateIRQ()
+	}
+	port.c2 = level
+}
+
+func (p *PIA) setC2(side int, level bool) {
+	p.ports[side].c2 = level
+	out := p.CA2Out
+	if side == 1 {
+		out = p.CB2Out
+	}
+	if out != nil {
+		out(level)
+	}
+}
+
+// controlOutput drives C2 when the control register makes it an output:
+// manual mode sets it from bit 3, the strobe modes idle high.
+func (p *PIA) controlOutput(side int) {
+	switch p.ports[side].cr & 0x38 {
+	case 0x30:
+		p.setC2(side, false)
+	case 0x38:
+		p.setC2(side, true)
+	case 0x20, 0x28:
+		if !p.ports[side].c2 {
+			p.setC2(side, true)
+		}
+	}
+}
+
+// handshake drops C2 on a read of port A or a write of port B in the
+// strobe modes. In handshake mode C1 raises it again, a pulse lasts one
+// cycle.
+func (p *PIA) handshake(side int) {
+	switch p.ports[side].cr & 0x38 {
+	case 0x20:
+		p.setC2(side, false)
+	case 0x28:
+		p.setC2(side, false)
+		cpu.After(1, func(uint64) { p.setC2(side, true) })
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestPIAPorts(t *testing.T) {
+	testCPU(t)
+	p := NewPIA()
+	var pins byte
+	p.OutB = func(b byte) { pins = b }
+	p.InA = func() byte { return 0x5A }
+
+	// with CRB bit 2 clear $02 is the direction register
+	p.Write(2, 0x0F)
+	p.Write(3, 0x04)
+	p.Write(2, 0x35)
+	if pins != 0xF5 {
+		t.Errorf("port B pins $%02X, want $F5 with the inputs floating high", pins)
+	}
+	if ddr := p.ports[1].ddr; ddr != 0x0F {
+		t.Errorf("DDRB = $%02X, want $0F", ddr)
+	}
+
+	p.Write(1, 0x04)
+	if got := p.Read(0); got != 0x5A {
+		t.Errorf("port A reads $%02X, want the pins $5A", got)
+	}
+}
+
+func TestPIAInterrupt(t *testing.T) {
+	c := testCPU(t)
+	p := NewPIA()
+	// CA1 IRQ on rising edges, port A selected
+	p.Write(1, 0x07)
+
+	p.SetCA1(false)
+	if c.irq != 0 {
+		t.Fatal("IRQ on a falling edge")
+	}
+	p.SetCA1(true)
+	if c.irq == 0 || p.Read(1)&piaIRQ1 == 0 {
+		t.Fatal("no IRQ on a rising edge")
+	}
+	p.Read(0)
+	if c.irq != 0 || p.Read(1)&piaIRQ1 != 0 {
+		t.Error("reading port A did not clear the interrupt")
+	}
+
+	// the flag is set with the IRQ disabled, but does not interrupt
+	p.Write(1, 0x06)
+	p.SetCA1(false)
+	p.SetCA1(true)
+	if c.irq != 0 || p.Read(1)&piaIRQ1 == 0 {
+		t.Error("want the CA1 flag set without an IRQ while it is disabled")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+// PIT is a programmable interval timer: a 16-bit counter going down at
+// the CPU clock divided by the prescaler, interrupting when it runs out.
+//
+//	$00-$01 counter, reading $00 latches $01 for a consistent read
+//	$02-$03 reload value, writing $03 loads the counter too
+//	$04     prescaler: the counter steps every value+1 cycles
+//	$05     control: bit 0 run, bit 1 reload and go on when the counter
+//	        runs out (else stop at 0), bit 2 IRQ, bit 3 NMI
+//	$06     status: bit 7 ran out, cleared by reading
+//
+// The counter runs out on reaching 0, after reload steps, 65536 for a
+// reload of 0. As with the VIA, the counter is not stepped: it is worked
+// out from the cycle it started at and running out is a scheduler event.
+type PIT struct {
+	reload   uint16
+	prescale byte
+	control  byte
+	status   byte
+	latch    byte
+	irq      IRQLine
+
+	// the counter stood at start at cycle base, and stays there when stopped
+	base  uint64
+	start uint16
+	event *Event
+}
+
+const (
+	pitRun    = 0x01
+	pitRepeat = 0x02
+	pitIRQ    = 0x04
+	pitNMI    = 0x08
+
+	pitExpired = 0x80
+)
+
+func NewPIT() *PIT {
+	return &PIT{irq: cpu.NewIRQLine()}
+}
+
+func (p *PIT) Read(address uint16) byte {
+	switch address {
+	case 0:
+		count := p.counter()
+		p.latch = byte(count >> 8)
+		return byte(count)
+	case 1:
+		return p.latch
+	case 2:
+		return byte(p.reload)
+	case 3:
+		return byte(p.reload >> 8)
+	case 4:
+		return p.prescale
+	case 5:
+		return p.control
+	case 6:
+		status := p.status
+		p.status = 0
+		p.updateIRQ()
+		return status
+	}
+	return 0
+}
+
+func (p *PIT) Write(address uint16, value byte) {
+	switch address {
+	case 2:
+		p.reload = p.reload&0xFF00 | uint16(value)
+	case 3:
+		p.reload = p.reload&0x00FF | uint16(value)<<8
+		p.load(p.reload)
+	case 4:
+		p.load(p.counter())
+		p.prescale = value
+		p.load(p.start)
+	case 5:
+		count := p.counter()
+		if count == 0 {
+			// started again after running out
+			count = p.reload
+		}
+		p.load(count)
+		p.control = value
+		p.load(p.start)
+		p.updateIRQ()
+	}
+}
+
+func (p *PIT) divider() uint64 {
+	return uint64(p.prescale) + 1
+}
+
+// pitSteps is how many steps the counter takes from value to run out.
+func pitSteps(value uint16) uint64 {
+	if value == 0 {
+		return 0x10000
+	}
+	return uint64(value)
+}
+
+func (p *PIT) counter() uint16 {
+	if p.event == nil {
+		return p.start
+	}
+	return p.start - uint16((cpu.Cycles-p.base)/p.divider())
+}
+
+// load starts the counter from value now, counting if the timer runs.
+func (p *PIT) load(value uint16) {
+	p.base = cpu.Cycles
+	p.start = value
+	cpu.Scheduler.Cancel(p.event)
+	p.event = nil
+	if p.control&pitRun != 0 {
+		p.event = cpu.Scheduler.At(p.base+pitSteps(value)*p.divider(), p.expire)
+	}
+}
+
+func (p *PIT) expire(cycle uint64) {
+	p.event = nil
+	p.status |= pitExpired
+	if p.control&pitNMI != 0 {
+		cpu.NMI()
+	}
+	p.updateIRQ()
+
+	if p.control&pitRepeat == 0 {
+		p.control &^= pitRun
+		p.start = 0
+		return
+	}
+	p.base = cycle
+	p.start = p.reload
+	p.event = cpu.Scheduler.At(cycle+pitSteps(p.reload)*p.divider(), p.expire)
+}
+
+func (p *PIT) updateIRQ() {
+	cpu.SetIRQ(p.irq, p.status&pitExpired != 0 && p.control&pitIRQ != 0)
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestPITRepeat(t *testing.T) {
+	c := testCPU(t)
+	p := NewPIT()
+	p.Write(2, 100)
+	p.Write(3, 0)
+	base := c.Cycles
+	p.Write(5, pitRun|pitRepeat|pitIRQ)
+
+	advance(c, 40)
+	if low, high := p.Read(0), p.Read(1); low != 60 || high != 0 {
+		t.Errorf("counter = $%02X%02X after 40 cycles, want 60", high, low)
+	}
+
+	for _, at := range []uint64{100, 200} {
+		advance(c, base+at-1-c.Cycles)
+		if c.irq != 0 {
+			t.Fatalf("IRQ before cycle %d", at)
+		}
+		advance(c, 1)
+		if c.irq == 0 {
+			t.Fatalf("no IRQ at cycle %d", at)
+		}
+		if status := p.Read(6); status != pitExpired {
+			t.Errorf("status = $%02X, want $80", status)
+		}
+		if c.irq != 0 {
+			t.Error("reading the status did not clear the IRQ")
+		}
+	}
+}
+
+func TestPITPrescaler(t *testing.T) {
+	c := testCPU(t)
+	p := NewPIT()
+	p.Write(4, 3)
+	p.Write(2, 10)
+	p.Write(3, 0)
+	base := c.Cycles
+	p.Write(5, pitRun|pitNMI)
+
+	advance(c, base+39-c.Cycles)
+	if c.nmiPending {
+		t.Fatal("NMI before 10 steps of 4 cycles")
+	}
+	advance(c, 1)
+	if !c.nmiPending {
+		t.Fatal("no NMI after 10 steps of 4 cycles")
+	}
+
+	// without repeat the timer stops at 0
+	c.nmiPending = false
+	advance(c, 1000)
+	if c.nmiPending || p.Read(5)&pitRun != 0 || p.Read(0) != 0 {
+		t.Error("the timer ran on without repeat")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WritePprof writes the profile as a gzipped pprof protobuf, for go tool
+// pprof: a sample per call stack and address, with instructions and
+// cycles as values. Each location is an address in the function that ran
+// it, so pprof can show the instructions, functions and call graph.
+func (p *Profile) WritePprof(w io.Writer) error {
+	var b protoBuffer
+	stringIDs := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) int {
+		if _, ok := stringIDs[s]; !ok {
+			stringIDs[s] = len(table)
+			table = append(table, s)
+		}
+		return stringIDs[s]
+	}
+
+	for _, valueType := range [][2]string{{"instructions", "count"}, {"cycles", "count"}} {
+		var v protoBuffer
+		v.int(1, str(valueType[0]))
+		v.int(2, str(valueType[1]))
+		b.message(1, v)
+	}
+
+	// functions by entry and locations by address and function, ids
+	// counting from 1
+	functions := make(map[uint16]int)
+	type locationKey struct{ pc, function uint16 }
+	locations := make(map[locationKey]int)
+	var functionList []uint16
+	var locationList []locationKey
+	location := func(pc, function uint16) uint64 {
+		if functions[function] == 0 {
+			functionList = append(functionList, function)
+			functions[function] = len(functionList)
+		}
+		key := locationKey{pc, function}
+		if locations[key] == 0 {
+			locationList = append(locationList, key)
+			locations[key] = len(locationList)
+		}
+		return uint64(locations[key])
+	}
+
+	for _, site := range p.sites() {
+		frames := p.stacks[site.stack]
+		ids := []uint64{location(site.pc, p.function(frames))}
+		for i := len(frames) - 1; i >= 0; i-- {
+			ids = append(ids, location(frames[i].From, p.function(frames[:i])))
+		}
+
+		cost := p.costs[site]
+		var sample protoBuffer
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{cost.Instructions, cost.Cycles})
+		b.message(2, sample)
+	}
+
+	for i, key := range locationList {
+		var line, loc protoBuffer
+		line.int(1, functions[key.function])
+		loc.int(1, i+1)
+		loc.uint(3, uint64(key.pc))
+		loc.message(4, line)
+		b.message(4, loc)
+	}
+
+	for i, entry := range functionList {
+		var function protoBuffer
+		function.int(1, i+1)
+		function.int(2, str(p.functionName(entry)))
+		function.int(3, str(p.functionName(entry)))
+		b.message(5, function)
+	}
+
+	var period protoBuffer
+	period.int(1, str("cycles"))
+	period.int(2, str("count"))
+	b.message(11, period)
+	b.uint(12, 1)
+
+	// the string table goes last, once every string is in it
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(b); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// protoBuffer encodes protocol buffer fields, enough of the wire format
+// for pprof profiles.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+// uint writes a varint field, which proto3 leaves out when it is zero.
+func (b *protoBuffer) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int(field, x int) {
+	b.uint(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) message(field int, m protoBuffer) {
+	b.bytes(field, m)
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var data protoBuffer
+	for _, x := range xs {
+		data.varint(x)
+	}
+	b.bytes(field, data)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Profile counts the instructions run and the cycles they took at every
+// address, split by the call stack they ran under, so costs add up by
+// function as well as by instruction. Functions are the routines called
+// by JSR and the BRK, IRQ and NMI handlers, named after their symbols;
+// the code run with nothing on the call stack is the root function.
+// Cycles spent in interrupt sequences are not counted.
+type Profile struct {
+	// root is the first address run with an empty call stack
+	root    uint16
+	started bool
+
+	// stacks interns the call stacks, outermost call first, by their
+	// frames' addresses. stack is the current one and stale says the CPU
+	// has called or returned since it was looked up.
+	stackIDs map[string]int
+	stacks   [][]CallFrame
+	stack    int
+	stale    bool
+
+	costs map[profileSite]*profileCost
+	calls map[profileArc]uint64
+}
+
+type profileSite struct {
+	stack int
+	pc    uint16
+}
+
+type profileCost struct {
+	Instructions uint64
+	Cycles       uint64
+}
+
+func (c *profileCost) add(o profileCost) {
+	c.Instructions += o.Instructions
+	c.Cycles += o.Cycles
+}
+
+// profileArc is a call from the function at caller, made at site, to the
+// function at callee.
+type profileArc struct {
+	caller, site, callee uint16
+}
+
+var profileFormats = []string{"text", "callgrind", "pprof"}
+
+func NewProfile() *Profile {
+	return &Profile{
+		stackIDs: map[string]int{"": 0},
+		stacks:   [][]CallFrame{nil},
+		costs:    make(map[profileSite]*profileCost),
+		calls:    make(map[profileArc]uint64),
+	}
+}
+
+// moved tells the profile the call stack changed.
+func (p *Profile) moved() {
+	if p != nil {
+		p.stale = true
+	}
+}
+
+// called counts a call about to be pushed onto calls.
+func (p *Profile) called(calls []CallFrame, from, to uint16) {
+	if p != nil {
+		p.calls[profileArc{p.function(calls), from, to}]++
+	}
+}
+
+// function returns the entry of the function running under calls.
+func (p *Profile) function(calls []CallFrame) uint16 {
+	if len(calls) == 0 {
+		return p.root
+	}
+	return calls[len(calls)-1].To
+}
+
+// site returns where an instruction at pc is counted, with the call stack
+// as it is before the instruction runs.
+func (p *Profile) site(c *CPU, pc uint16) profileSite {
+	if !p.started && len(c.calls) == 0 {
+		p.root, p.started = pc, true
+	}
+
+	if p.stale {
+		p.stale = false
+		key := make([]byte, 0, len(c.calls)*4)
+		for _, frame := range c.calls {
+			key = append(key, byte(frame.From), byte(frame.From>>8), byte(frame.To), byte(frame.To>>8))
+		}
+
+		id, ok := p.stackIDs[string(key)]
+		if !ok {
+			id = len(p.stacks)
+			p.stackIDs[string(key)] = id
+			p.stacks = append(p.stacks, append([]CallFrame(nil), c.calls...))
+		}
+		p.stack = id
+	}
+	return profileSite{p.stack, pc}
+}
+
+// count adds an instruction run at site.
+func (p *Profile) count(site profileSite, cycles uint64) {
+	cost := p.costs[site]
+	if cost == nil {
+		cost = &profileCost{}
+		p.costs[site] = cost
+	}
+	cost.Instructions++
+	cost.Cycles += cycles
+}
+
+// functionName names the function at entry.
+func (p *Profile) functionName(entry uint16) string {
+	if name := cpu.Symbols.Name(entry); name != "" {
+		return name
+	}
+	return fmt.Sprintf("$%04X", entry)
+}
+
+// sites returns the counted sites in address order.
+func (p *Profile) sites() []profileSite {
+	sites := make([]profileSite, 0, len(p.costs))
+	for site := range p.costs {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].pc != sites[j].pc {
+			return sites[i].pc < sites[j].pc
+		}
+		return sites[i].stack < sites[j].stack
+	})
+	return sites
+}
+
+func (p *Profile) total() profileCost {
+	var total profileCost
+	for _, cost := range p.costs {
+		total.add(*cost)
+	}
+	return total
+}
+
+// WriteFile writes the profile in a format of profileFormats.
+func (p *Profile) WriteFile(path, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	switch format {
+	case "text":
+		p.WriteText(w)
+	case "callgrind":
+		p.WriteCallgrind(w)
+	case "pprof":
+		err = p.WritePprof(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// functionCost is the cost of a function: self for its own instructions
+// and total for those with the functions it called, counted once however
+// deep it recursed.
+type functionCost struct {
+	entry       uint16
+	self, total profileCost
+	calls       uint64
+}
+
+func (p *Profile) functionCosts() []*functionCost {
+	functions := make(map[uint16]*functionCost)
+	get := func(entry uint16) *functionCost {
+		f := functions[entry]
+		if f == nil {
+			f = &functionCost{entry: entry}
+			functions[entry] = f
+		}
+		return f
+	}
+
+	for site, cost := range p.costs {
+		frames := p.stacks[site.stack]
+		get(p.function(frames)).self.add(*cost)
+
+		seen := map[uint16]bool{p.root: true}
+		get(p.root).total.add(*cost)
+		for _, frame := range frames {
+			if !seen[frame.To] {
+				seen[frame.To] = true
+				get(frame.To).total.add(*cost)
+			}
+		}
+	}
+	for arc, count := range p.calls {
+		get(arc.callee).calls += count
+	}
+
+	list := make([]*functionCost, 0, len(functions))
+	for _, f := range functions {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].self.Cycles != list[j].self.Cycles {
+			return list[i].self.Cycles > list[j].self.Cycles
+		}
+		return list[i].entry < list[j].entry
+	})
+	return list
+}
+
+// hotInstructions is how many instructions the text report lists.
+const hotInstructions = 25
+
+// WriteText writes a flat profile: the functions by the cycles spent in
+// them, then the instructions that took the most cycles.
+func (p *Profile) WriteText(w io.Writer) {
+	total := p.total()
+	fmt.Fprintf(w, "%d instructions, %d cycles\n\n", total.Instructions, total.Cycles)
+	percent := func(cycles uint64) float64 {
+		if total.Cycles == 0 {
+			return 0
+		}
+		return 100 * float64(cycles) / float64(total.Cycles)
+	}
+
+	fmt.Fprintf(w, "%12s %6s %12s %6s %12s %8s  %s\n",
+		"self cycles", "", "total cycles", "", "instructions", "calls", "function")
+	for _, f := range p.functionCosts() {
+		fmt.Fprintf(w, "%12d %5.1f%% %12d %5.1f%% %12d %8d  %s\n",
+			f.self.Cycles, percent(f.self.Cycles), f.total.Cycles, percent(f.total.Cycles),
+			f.self.Instructions, f.calls, p.functionName(f.entry))
+	}
+
+	byPC := make(map[uint16]*profileCost)
+	for site, cost := range p.costs {
+		if byPC[site.pc] == nil {
+			byPC[site.pc] = &profileCost{}
+		}
+		byPC[site.pc].add(*cost)
+	}
+	addresses := make([]uint16, 0, len(byPC))
+	for pc := range byPC {
+		addresses = append(addresses, pc)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		a, b := byPC[addresses[i]], byPC[addresses[j]]
+		if a.Cycles != b.Cycles {
+			return a.Cycles > b.Cycles
+		}
+		return addresses[i] < addresses[j]
+	})
+
+	fmt.Fprintf(w, "\n%12s %6s %12s  %-16s %s\n", "cycles", "", "count", "instruction", "address")
+	for _, pc := range addresses[:min(len(addresses), hotInstructions)] {
+		text, _ := Disassemble(pc, cpu.Bus.Read)
+		fmt.Fprintf(w, "%12d %5.1f%% %12d  %-16s %s\n",
+			byPC[pc].Cycles, percent(byPC[pc].Cycles), byPC[pc].Instructions, text, symbolic(pc))
+	}
+}
+
+// WriteCallgrind writes the profile for KCachegrind and callgrind_annotate,
+// with instruction addresses as positions and instructions and cycles as
+// events.
+func (p *Profile) WriteCallgrind(w io.Writer) {
+	total := p.total()
+	fmt.Fprintln(w, "# callgrind format")
+	fmt.Fprintln(w, "version: 1")
+	fmt.Fprintln(w, "creator: 6502_cpu_emulator")
+	fmt.Fprintln(w, "positions: instr")
+	fmt.Fprintln(w, "events: Instructions Cycles")
+	fmt.Fprintf(w, "summary: %d %d\n", total.Instructions, total.Cycles)
+
+	// self costs by function and address, and inclusive costs by arc
+	self := make(map[uint16]map[uint16]*profileCost)
+	inclusive := make(map[profileArc]*profileCost)
+	for site, cost := range p.costs {
+		frames := p.stacks[site.stack]
+		function := p.function(frames)
+		if self[function] == nil {
+			self[function] = make(map[uint16]*profileCost)
+		}
+		if self[function][site.pc] == nil {
+			self[function][site.pc] = &profileCost{}
+		}
+		self[function][site.pc].add(*cost)
+
+		for i, frame := range frames {
+			arc := profileArc{p.function(frames[:i]), frame.From, frame.To}
+			if inclusive[arc] == nil {
+				inclusive[arc] = &profileCost{}
+			}
+			inclusive[arc].add(*cost)
+		}
+	}
+
+	arcs := make(map[uint16][]profileArc)
+	for arc := range p.calls {
+		arcs[arc.caller] = append(arcs[arc.caller], arc)
+	}
+	for arc := range inclusive {
+		if p.calls[arc] == 0 {
+			arcs[arc.caller] = append(arcs[arc.caller], arc)
+		}
+	}
+
+	functions := make([]uint16, 0, len(self)+len(arcs))
+	for function := range self {
+		functions = append(functions, function)
+	}
+	for function := range arcs {
+		if self[function] == nil {
+			functions = append(functions, function)
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i] < functions[j] })
+
+	for _, function := range functions {
+		fmt.Fprintf(w, "\nfn=%s\n", p.functionName(function))
+		addresses := make([]uint16, 0, len(self[function]))
+		for pc := range self[function] {
+			addresses = append(addresses, pc)
+		}
+		sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+		for _, pc := range addresses {
+			cost := self[function][pc]
+			fmt.Fprintf(w, "0x%04X %d %d\n", pc, cost.Instructions, cost.Cycles)
+		}
+
+		sort.Slice(arcs[function], func(i, j int) bool {
+			a, b := arcs[function][i], arcs[function][j]
+			if a.site != b.site {
+				return a.site < b.site
+			}
+			return a.callee < b.callee
+		})
+		for _, arc := range arcs[function] {
+			cost := profileCost{}
+			if inclusive[arc] != nil {
+				cost = *inclusive[arc]
+			}
+			fmt.Fprintf(w, "cfn=%s\n", p.functionName(arc.callee))
+			fmt.Fprintf(w, "calls=%d 0x%04X\n", p.calls[arc], arc.callee)
+			fmt.Fprintf(w, "0x%04X %d %d\n", arc.site, cost.Instructions, cost.Cycles)
+		}
+	}
+}
+
+// ParseProfileFormat checks a -profile-format value.
+func ParseProfileFormat(s string) (string, error) {
+	for _, format := range profileFormats {
+		if s == format {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown profile format %q, choose from %s", s, strings.Join(profileFormats, ", "))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// profileCPU runs a program with Main calling Sub, which calls Rec, which
+// calls itself once more, and returns the profile.
+func profileCPU(t *testing.T) *Profile {
+	t.Helper()
+	program := make([]byte, 0x30)
+	copy(program, []byte{0x20, 0x10, 0x80, 0xEA})                          // JSR Sub, NOP
+	copy(program[0x10:], []byte{0xA2, 0x02, 0x20, 0x20, 0x80, 0x60})       // LDX #2, JSR Rec, RTS
+	copy(program[0x20:], []byte{0xCA, 0xF0, 0x03, 0x20, 0x20, 0x80, 0x60}) // DEX, BEQ +3, JSR Rec, RTS
+	c := testCPU(t, program...)
+
+	path := filepath.Join(t.TempDir(), "program.lbl")
+	labels := "al 008000 .Main\nal 008010 .Sub\nal 008020 .Rec\n"
+	if err := os.WriteFile(path, []byte(labels), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if c.Symbols, err = LoadSymbols(path); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Profile = NewProfile()
+	if err := c.Run(func() bool { return false }); err != ErrHalted {
+		t.Fatalf("Run returned %v", err)
+	}
+	return c.Profile
+}
+
+func TestProfileFunctionCosts(t *testing.T) {
+	p := profileCPU(t)
+	costs := make(map[uint16]functionCost)
+	for _, f := range p.functionCosts() {
+		costs[f.entry] = *f
+	}
+
+	// Rec runs DEX, BEQ, JSR, then DEX, BEQ taken, RTS, and its own RTS:
+	// its total counts the recursive call's cycles once
+	want := map[uint16]functionCost{
+		0x8000: {entry: 0x8000, self: profileCost{1, 6}, total: profileCost{11, 47}},
+		0x8010: {entry: 0x8010, self: profileCost{3, 14}, total: profileCost{10, 41}, calls: 1},
+		0x8020: {entry: 0x8020, self: profileCost{7, 27}, total: profileCost{7, 27}, calls: 2},
+	}
+	for entry, f := range want {
+		if costs[entry] != f {
+			t.Errorf("%s costs %+v, want %+v", p.functionName(entry), costs[entry], f)
+		}
+	}
+	if len(costs) != len(want) {
+		t.Errorf("%d functions, want %d", len(costs), len(want))
+	}
+}
+
+func TestProfileCallgrind(t *testing.T) {
+	var out bytes.Buffer
+	profileCPU(t).WriteCallgrind(&out)
+
+	for _, want := range []string{
+		"summary: 11 47\n",
+		"fn=Main\n0x8000 1 6\ncfn=Sub\ncalls=1 0x8010\n0x8000 10 41\n",
+		"fn=Sub\n0x8010 1 2\n0x8012 1 6\n0x8015 1 6\ncfn=Rec\ncalls=1 0x8020\n0x8012 7 27\n",
+		"cfn=Rec\ncalls=1 0x8020\n0x8023 3 11\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("callgrind output has no\n%s\nin\n%s", want, out.String())
+		}
+	}
+}
+
+func TestProfilePprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := profileCPU(t).WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"instructions", "cycles", "Main", "Sub", "Rec"} {
+		if !bytes.Contains(data, []byte(name)) {
+			t.Errorf("pprof string table has no %q", name)
+		}
+	}
+}
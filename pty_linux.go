@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPty creates a pseudo-terminal in raw mode and returns its master
+// end and the name of the slave device for the user to connect to. The
+// slave end is returned open too: while it is, the master does not see a
+// hangup when no terminal program is attached.
+func openPty() (master, slave *os.File, name string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var unlock int32
+	if err := ptyIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+
+	var number uint32
+	if err := ptyIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+
+	name = fmt.Sprintf("/dev/pts/%d", number)
+	slave, err = os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+
+	if err := makeRaw(slave.Fd()); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, "", err
+	}
+
+	return master, slave, name, nil
+}
+
+func ptyIoctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
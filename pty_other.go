@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func openPty() (master, slave *os.File, name string, err error) {
+	return nil, nil, "", errors.New("pseudo-terminal links are only supported on Linux")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// RawConsole is a console for interactive programs. The terminal is put
+// in raw mode so keystrokes arrive one at a time, and reading never
+// blocks:
+//
+//	$00 read: next key, 0 if none. write: print the byte
+//	$01 read: bit 7 key available, bit 0 IRQ enabled. write: bit 0
+//	    enables the IRQ, held while a key is waiting
+type RawConsole struct {
+	keys    chan byte
+	key     byte
+	ready   bool
+	irqOn   bool
+	irq     IRQLine
+	restore func()
+}
+
+// how often keys are picked up for the IRQ, in seconds of emulated time
+const rawConsolePoll = 0.001
+
+func NewRawConsole() (*RawConsole, error) {
+	restore, err := rawInput(os.Stdin.Fd())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &RawConsole{keys: make(chan byte, 256), irq: cpu.NewIRQLine(), restore: restore}
+	go c.readKeys()
+	cpu.After(c.pollCycles(), c.poll)
+	return c, nil
+}
+
+func (c *RawConsole) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		for _, b := range buf[:n] {
+			c.keys <- b
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *RawConsole) pollCycles() uint64 {
+	return uint64(cpu.Frequency*rawConsolePoll) + 1
+}
+
+func (c *RawConsole) poll(cycle uint64) {
+	cpu.Scheduler.At(cycle+c.pollCycles(), c.poll)
+	c.fetch()
+}
+
+// fetch latches the next keystroke if the last one has been read.
+func (c *RawConsole) fetch() {
+	if !c.ready {
+		select {
+		case c.key = <-c.keys:
+			c.ready = true
+		default:
+		}
+	}
+	cpu.SetIRQ(c.irq, c.irqOn && c.ready)
+}
+
+func (c *RawConsole) Read(address uint16) byte {
+	c.fetch()
+	if address&0x01 != 0 {
+		var status byte
+		if c.ready {
+			status |= 0x80
+		}
+		if c.irqOn {
+			status |= 0x01
+		}
+		return status
+	}
+
+	if !c.ready {
+		return 0
+	}
+
+	c.ready = false
+	cpu.SetIRQ(c.irq, false)
+	return c.key
+}
+
+func (c *RawConsole) Write(address uint16, value byte) {
+	if address&0x01 != 0 {
+		c.irqOn = value&0x01 != 0
+		c.fetch()
+		return
+	}
+
+	fmt.Printf("%c", rune(value))
+}
+
+func (c *RawConsole) Close() error {
+	c.restore()
+	return nil
+}
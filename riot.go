@@ -0,0 +1,195 @@
+package main
+
+// RIOT is the I/O and timer part of a 6530 or 6532 RAM-I/O-Timer: two
+// 8-bit ports with data direction registers and an interval timer. The
+// chip's RAM (and the 6530's mask ROM) are plain memory and attached on
+// their own.
+//
+//	$00 port A   $01 DDRA   $02 port B   $03 DDRB
+//	write $04-$07: start the timer counting every 1, 8, 64 or 1024
+//	      cycles, $0C-$0F the same with its interrupt enabled
+//	read  $04/$0C: the timer, bit 3 enables the interrupt
+//	read  $05/$07: interrupt flags, bit 7 timer, bit 6 PA7 on the 6532
+//
+// The 6532 decodes A4 as well: writes to $04-$07 with A4 clear set up the
+// PA7 edge interrupt instead, bit 0 picking the rising edge and bit 1
+// enabling it.
+//
+// Like the VIA's, the timer is not stepped: the count is worked out from
+// the cycle it was written at and the underflow is a scheduler event.
+type RIOT struct {
+	// InA and InB give the levels on the port pins the RIOT does not
+	// drive, unconnected pins read high
+	InA func() byte
+	InB func() byte
+	// OutA and OutB are called with the port pins after the output or
+	// direction register changes, inputs float high
+	OutA func(pins byte)
+	OutB func(pins byte)
+
+	model int
+	ports [2]riotPort
+	irq   IRQLine
+
+	timerBase  uint64
+	timerValue byte
+	prescale   uint64
+	timerIRQ   bool
+	timerEvent *Event
+	flags      byte
+
+	edgeRising bool
+	edgeIRQ    bool
+	pa7        bool
+}
+
+type riotPort struct {
+	or, ddr byte
+}
+
+const (
+	RIOT6530 = iota
+	RIOT6532
+)
+
+// interrupt flag bits
+const (
+	riotPA7   = 0x40
+	riotTimer = 0x80
+)
+
+var riotPrescales = [4]uint64{1, 8, 64, 1024}
+
+func NewRIOT(model int) *RIOT {
+	return newRIOT(model, cpu.NewIRQLine())
+}
+
+func newRIOT(model int, irq IRQLine) *RIOT {
+	return &RIOT{model: model, irq: irq, prescale: 1, pa7: true}
+}
+
+func (r *RIOT) Read(address uint16) byte {
+	if address&0x04 == 0 {
+		side := int(address>>1) & 0x01
+		port := &r.ports[side]
+		if address&0x01 != 0 {
+			return port.ddr
+		}
+		if side == 0 {
+			r.samplePA7()
+		}
+		return port.or&port.ddr | r.pinsIn(side)&^port.ddr
+	}
+
+	if address&0x01 != 0 {
+		flags := r.flags
+		r.flags &^= riotPA7
+		r.updateIRQ()
+		return flags
+	}
+
+	r.timerIRQ = address&0x08 != 0
+	value := r.timer()
+	r.flags &^= riotTimer
+	r.updateIRQ()
+	return value
+}
+
+func (r *RIOT) Write(address uint16, value byte) {
+	if address&0x04 == 0 {
+		side := int(address>>1) & 0x01
+		port := &r.ports[side]
+		if address&0x01 != 0 {
+			port.ddr = value
+		} else {
+			port.or = value
+		}
+		r.output(side)
+		return
+	}
+
+	if r.model == RIOT6532 && address&0x10 == 0 {
+		r.edgeRising = address&0x01 != 0
+		r.edgeIRQ = address&0x02 != 0
+		r.updateIRQ()
+		return
+	}
+
+	r.prescale = riotPrescales[address&0x03]
+	r.timerIRQ = address&0x08 != 0
+	r.flags &^= riotTimer
+	r.loadTimer(cpu.Cycles, value)
+	r.updateIRQ()
+}
+
+func (r *RIOT) pinsIn(side int) byte {
+	in := r.InA
+	if side == 1 {
+		in = r.InB
+	}
+	if in == nil {
+		return 0xFF
+	}
+	return in()
+}
+
+func (r *RIOT) output(side int) {
+	out := r.OutA
+	if side == 1 {
+		out = r.OutB
+	}
+	if out != nil {
+		port := &r.ports[side]
+		out(port.or&port.ddr | ^port.ddr)
+	}
+}
+
+func (r *RIOT) updateIRQ() {
+	cpu.SetIRQ(r.irq, r.flags&riotTimer != 0 && r.timerIRQ ||
+		r.flags&riotPA7 != 0 && r.edgeIRQ)
+}
+
+// SetPA7 tells the 6532 the level on PA7 changed, for its edge interrupt.
+func (r *RIOT) SetPA7(level bool) {
+	if r.model == RIOT6532 && level != r.pa7 && level == r.edgeRising {
+		r.flags |= riotPA7
+		r.updateIRQ()
+	}
+	r.pa7 = level
+}
+
+func (r *RIOT) samplePA7() {
+	if r.ports[0].ddr&0x80 == 0 {
+		r.SetPA7(r.pinsIn(0)&0x80 != 0)
+	}
+}
+
+// Timer
+
+// loadTimer starts the count from value. It goes down by one every
+// prescale cycles, and once past zero by one every cycle from $FF, with
+// the interrupt flag set.
+func (r *RIOT) loadTimer(base uint64, value byte) {
+	r.timerBase = base
+	r.timerValue = value
+	if r.timerEvent != nil {
+		cpu.Scheduler.Cancel(r.timerEvent)
+	}
+	r.timerEvent = cpu.Scheduler.At(base+(uint64(value)+1)*r.prescale, r.timerUnderflow)
+}
+
+func (r *RIOT) timer() byte {
+	elapsed := cpu.Cycles - r.timerBase
+	if r.timerEvent == nil {
+		return r.timerValue - byte(elapsed)
+	}
+	return r.timerValue - byte(elapsed/r.prescale)
+}
+
+func (r *RIOT) timerUnderflow(cycle uint64) {
+	r.timerEvent = nil
+	r.timerBase = cycle
+	r.timerValue = 0xFF
+	r.flags |= riotTimer
+	r.updateIRQ()
+}
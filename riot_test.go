@@ -0,0 +1,101 @@
+package main
+
+import "testing"
+
+func TestRIOTTimer(t *testing.T) {
+	c := testCPU(t)
+	r := NewRIOT(RIOT6530)
+	base := c.Cycles
+	// count 2 at 1024 cycles a step, interrupt enabled
+	r.Write(0x0F, 2)
+
+	advance(c, 1024)
+	if got := r.Read(0x0C); got != 1 {
+		t.Errorf("timer = %d after one step, want 1", got)
+	}
+	advance(c, base+3*1024-1-c.Cycles)
+	if c.irq != 0 || r.Read(0x05)&riotTimer != 0 {
+		t.Fatal("timer ran out early")
+	}
+	advance(c, 1)
+	if c.irq == 0 || r.Read(0x05)&riotTimer == 0 {
+		t.Fatal("no interrupt when the timer ran out")
+	}
+
+	// past zero it counts every cycle from $FF
+	advance(c, 5)
+	if got := r.Read(0x0C); got != 0xFA {
+		t.Errorf("timer = $%02X 5 cycles after running out, want $FA", got)
+	}
+	if c.irq != 0 || r.Read(0x05)&riotTimer != 0 {
+		t.Error("reading the timer did not clear the interrupt")
+	}
+}
+
+func TestRIOTTimerNoIRQ(t *testing.T) {
+	c := testCPU(t)
+	r := NewRIOT(RIOT6530)
+	// count 10 every cycle, interrupt disabled
+	r.Write(0x04, 10)
+	advance(c, 11)
+	if c.irq != 0 {
+		t.Error("IRQ with the timer interrupt disabled")
+	}
+	if r.Read(0x07)&riotTimer == 0 {
+		t.Error("no timer flag")
+	}
+}
+
+func TestRIOTPorts(t *testing.T) {
+	testCPU(t)
+	r := NewRIOT(RIOT6530)
+	var pins byte
+	r.OutA = func(b byte) { pins = b }
+	r.InB = func() byte { return 0x81 }
+
+	r.Write(0x01, 0xF0)
+	r.Write(0x00, 0x3C)
+	if pins != 0x3F {
+		t.Errorf("port A pins $%02X, want $3F with the inputs floating high", pins)
+	}
+	if got := r.Read(0x01); got != 0xF0 {
+		t.Errorf("DDRA = $%02X, want $F0", got)
+	}
+
+	r.Write(0x03, 0x0F)
+	r.Write(0x02, 0x06)
+	if got := r.Read(0x02); got != 0x86 {
+		t.Errorf("port B reads $%02X, want outputs $06 and inputs $80", got)
+	}
+}
+
+func TestRIOTPA7Edge(t *testing.T) {
+	c := testCPU(t)
+	r := NewRIOT(RIOT6532)
+	// rising edge, interrupt enabled
+	r.Write(0x07, 0)
+
+	r.SetPA7(false)
+	if c.irq != 0 {
+		t.Fatal("IRQ on a falling edge")
+	}
+	r.SetPA7(true)
+	if c.irq == 0 {
+		t.Fatal("no IRQ on a rising edge")
+	}
+	if r.Read(0x05)&riotPA7 == 0 {
+		t.Error("no PA7 flag")
+	}
+	if c.irq != 0 || r.Read(0x05)&riotPA7 != 0 {
+		t.Error("reading the flags did not clear the PA7 interrupt")
+	}
+
+	// the 6530 has no edge detector
+	r = NewRIOT(RIOT6530)
+	r.Write(0x07, 0)
+	r.SetPA7(false)
+	r.SetPA7(true)
+	if r.Read(0x05)&riotPA7 != 0 {
+		t.Error("PA7 edge on a 6530")
+	}
+}
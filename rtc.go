@@ -0,0 +1,70 @@
+package main
+
+import "time"
+
+// RTC is a real-time clock showing the host's local date and time in BCD.
+//
+//	$00 seconds   $01 minutes   $02 hours (0-23)
+//	$03 day of the week, 0 for Sunday
+//	$04 day of the month   $05 month   $06 year   $07 century
+//
+// Reading the seconds latches the whole time, so reading up from $00
+// gives one consistent time. Writing a register sets the clock, which then
+// keeps its distance from the host clock; the host clock is not changed.
+type RTC struct {
+	offset  time.Duration
+	latched [8]byte
+}
+
+func NewRTC() *RTC {
+	r := &RTC{}
+	r.latch(r.now())
+	return r
+}
+
+func (r *RTC) now() time.Time {
+	return time.Now().Add(r.offset)
+}
+
+func (r *RTC) latch(t time.Time) {
+	r.latched = [8]byte{
+		toBCD(t.Second()), toBCD(t.Minute()), toBCD(t.Hour()),
+		toBCD(int(t.Weekday())),
+		toBCD(t.Day()), toBCD(int(t.Month())), toBCD(t.Year() % 100), toBCD(t.Year() / 100),
+	}
+}
+
+func (r *RTC) Read(address uint16) byte {
+	if address >= uint16(len(r.latched)) {
+		return 0
+	}
+	if address == 0 {
+		r.latch(r.now())
+	}
+	return r.latched[address]
+}
+
+// Write changes one field of the current time. The day of the week
+// follows from the date and is not written.
+func (r *RTC) Write(address uint16, value byte) {
+	if address >= uint16(len(r.latched)) || address == 3 {
+		return
+	}
+
+	now := r.now()
+	r.latch(now)
+	r.latched[address] = value
+	f := func(i int) int { return fromBCD(r.latched[i]) }
+
+	t := time.Date(f(7)*100+f(6), time.Month(f(5)), f(4), f(2), f(1), f(0), now.Nanosecond(), time.Local)
+	r.offset += t.Sub(now)
+	r.latch(t)
+}
+
+func toBCD(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0F)
+}
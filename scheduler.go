@@ -0,0 +1,96 @@
+package main
+
+import "container/heap"
+
+// Scheduler lets devices run code at a given CPU cycle, so timers, video
+// blanking or serial bit times happen at the same point of the program
+// on every run. Events are run between instructions, or between cycles on
+// the per-cycle core, in cycle order and in scheduling order on ties.
+type Scheduler struct {
+	queue eventQueue
+	seq   uint64
+}
+
+// Event is a scheduled callback. Fn gets the cycle it was scheduled for,
+// which may be a few cycles before the current one outside the per-cycle
+// core.
+type Event struct {
+	At  uint64
+	Fn  func(cycle uint64)
+	seq uint64
+	// position in the queue, -1 once run or cancelled
+	index int
+}
+
+func (s *Scheduler) At(cycle uint64, fn func(cycle uint64)) *Event {
+	e := &Event{At: cycle, Fn: fn, seq: s.seq}
+	s.seq++
+	heap.Push(&s.queue, e)
+	return e
+}
+
+func (s *Scheduler) Cancel(e *Event) {
+	if e == nil || e.index < 0 {
+		return
+	}
+
+	heap.Remove(&s.queue, e.index)
+}
+
+// Next returns the cycle of the earliest pending event.
+func (s *Scheduler) Next() (uint64, bool) {
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+
+	return s.queue[0].At, true
+}
+
+// Run calls every event due at or before now, including events the
+// callbacks schedule for already passed cycles.
+func (s *Scheduler) Run(now uint64) {
+	for len(s.queue) > 0 && s.queue[0].At <= now {
+		e := heap.Pop(&s.queue).(*Event)
+		e.Fn(e.At)
+	}
+}
+
+// After schedules fn the given number of cycles from now.
+func (c *CPU) After(cycles uint64, fn func(cycle uint64)) *Event {
+	return c.Scheduler.At(c.Cycles+cycles, fn)
+}
+
+type eventQueue []*Event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].At != q[j].At {
+		return q[i].At < q[j].At
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*Event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
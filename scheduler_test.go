@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	var s Scheduler
+	var ran []string
+	event := func(name string) func(uint64) {
+		return func(uint64) { ran = append(ran, name) }
+	}
+	s.At(30, event("c"))
+	s.At(10, event("a"))
+	s.At(20, event("b1"))
+	s.At(20, event("b2"))
+	cancelled := s.At(15, event("cancelled"))
+	s.At(20, event("b3"))
+	s.Cancel(cancelled)
+	s.Cancel(cancelled)
+
+	s.Run(9)
+	if len(ran) != 0 {
+		t.Fatalf("ran %v before any event was due", ran)
+	}
+	s.Run(20)
+	if want := []string{"a", "b1", "b2", "b3"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if next, ok := s.Next(); !ok || next != 30 {
+		t.Errorf("Next = %d, %v, want 30", next, ok)
+	}
+}
+
+func TestSchedulerReschedule(t *testing.T) {
+	var s Scheduler
+	var cycles []uint64
+	var tick func(uint64)
+	tick = func(cycle uint64) {
+		cycles = append(cycles, cycle)
+		s.At(cycle+4, tick)
+	}
+	s.At(2, tick)
+
+	// events the callback schedules for passed cycles run at once, the
+	// rest wait
+	s.Run(11)
+	if want := []uint64{2, 6, 10}; !slices.Equal(cycles, want) {
+		t.Errorf("ran at %v, want %v", cycles, want)
+	}
+	if next, _ := s.Next(); next != 14 {
+		t.Errorf("next event at %d, want 14", next)
+	}
+}
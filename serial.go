@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SerialLink is the host side of a serial device. Incoming bytes are read
+// by a goroutine and queued, the CPU side polls for them without blocking.
+type SerialLink struct {
+	in chan byte
+
+	mu  sync.Mutex
+	out io.Writer
+	// closers are the host resources the link holds besides out
+	closers []io.Closer
+	closed  bool
+
+	// terminal translates the host's newlines to the CR line endings
+	// monitors expect, and CR back to CR LF on output
+	terminal bool
+	lastCR   bool
+}
+
+// OpenSerialLink connects a serial device to "stdio", "pty" (a new
+// pseudo-terminal whose name is printed) or "tcp:address" (a listener,
+// one client at a time).
+func OpenSerialLink(spec string) (*SerialLink, error) {
+	switch {
+	case spec == "stdio":
+		l := newSerialLink(os.Stdout)
+		l.terminal = true
+		go l.receive(os.Stdin)
+		return l, nil
+	case spec == "pty":
+		master, slave, name, err := openPty()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "serial link on %s\n", name)
+		l := newSerialLink(master)
+		l.closers = []io.Closer{master, slave}
+		go l.receive(master)
+		return l, nil
+	case strings.HasPrefix(spec, "tcp:"):
+		listener, err := net.Listen("tcp", strings.TrimPrefix(spec, "tcp:"))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "serial link listening on %s\n", listener.Addr())
+		l := newSerialLink(io.Discard)
+		l.closers = []io.Closer{listener}
+		go l.accept(listener)
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("unknown serial link %q", spec)
+}
+
+func newSerialLink(out io.Writer) *SerialLink {
+	return &SerialLink{in: make(chan byte, 4096), out: out}
+}
+
+func (l *SerialLink) receive(r io.Reader) {
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if l.terminal && b == '\n' {
+				b = '\r'
+			}
+			l.in <- b
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (l *SerialLink) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if old, ok := l.out.(net.Conn); ok {
+			old.Close()
+		}
+		l.out = conn
+		l.mu.Unlock()
+		go l.receive(conn)
+	}
+}
+
+// Receive returns the next byte from the host if one has arrived.
+func (l *SerialLink) Receive() (byte, bool) {
+	select {
+	case b := <-l.in:
+		return b, true
+	default:
+		return 0, false
+	}
+}
+
+func (l *SerialLink) Send(b byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.terminal {
+		_, _ = l.out.Write([]byte{b})
+		return
+	}
+
+	switch {
+	case b == '\r':
+		_, _ = l.out.Write([]byte("\r\n"))
+	case b == '\n' && l.lastCR:
+	default:
+		_, _ = l.out.Write([]byte{b})
+	}
+	l.lastCR = b == '\r'
+}
+
+// Close releases the pty or the listener and its client. The goroutines
+// reading from them stop on the error. Closing twice does nothing.
+func (l *SerialLink) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	var errs []error
+	if conn, ok := l.out.(net.Conn); ok {
+		errs = append(errs, conn.Close())
+		l.out = io.Discard
+	}
+	for _, closer := range l.closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
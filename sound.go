@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// Sound is a three voice tone generator laid out after the SID, written
+// to a WAV file instead of played. Each voice has seven registers, the
+// first voice at $00, the second at $07 and the third at $0E:
+//
+//	+0/+1 frequency, the tone is value * clock / 2^24 Hz
+//	+2    pulse width: a square wave is high for value/256 of the period
+//	+3    control: bit 0 gate, starting the envelope's attack and its
+//	      release when cleared; waveform bit 4 triangle, bit 5 sawtooth,
+//	      bit 6 square, bit 7 noise, the highest one set is played
+//	+4    attack in the high nibble, decay in the low one
+//	+5    sustain level in the high nibble, release in the low one
+//	+6    volume 0-15
+//
+// $15 is the master volume, 0-15. Rates are the SID's: attack takes 2ms
+// to 8s, decay and release three times as long.
+//
+// Like the timers, the voices are not stepped. Samples are worked out at
+// a fixed rate of emulated time up to the current cycle whenever a
+// register is written, so a program gives the same file on every run.
+type Sound struct {
+	Rate int
+
+	voices [3]soundVoice
+	volume byte
+
+	f       *os.File
+	out     *bufio.Writer
+	samples uint64
+}
+
+type soundVoice struct {
+	regs [7]byte
+
+	phase float64
+	noise uint32
+	// noise output, changed eight times a period
+	noiseStep int
+	noiseBit  float64
+
+	level float64
+	stage int
+}
+
+const (
+	soundFrequency = iota
+	_
+	soundPulse
+	soundControl
+	soundAttackDecay
+	soundSustainRelease
+	soundVolume
+
+	soundMaster = 0x15
+)
+
+const (
+	soundGate     = 0x01
+	soundTriangle = 0x10
+	soundSawtooth = 0x20
+	soundSquare   = 0x40
+	soundNoise    = 0x80
+)
+
+// envelope stages
+const (
+	soundRelease = iota
+	soundAttack
+	soundDecay
+)
+
+// attack times in seconds, decay and release take three times as long
+var soundAttackTimes = [16]float64{
+	0.002, 0.008, 0.016, 0.024, 0.038, 0.056, 0.068, 0.080,
+	0.100, 0.250, 0.500, 0.800, 1, 3, 5, 8,
+}
+
+const wavHeaderSize = 44
+
+func NewSound(path string, rate int) (*Sound, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sound{Rate: rate, volume: 15, f: f, out: bufio.NewWriter(f)}
+	for i := range s.voices {
+		s.voices[i].noise = 0x7FFFF8
+	}
+
+	// the sizes are filled in on Close
+	if err := s.writeHeader(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Sound) writeHeader(dataSize uint32) error {
+	header := make([]byte, 0, wavHeaderSize)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, 36+dataSize)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	// PCM, mono, 16 bits
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint32(header, uint32(s.Rate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(s.Rate)*2)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, dataSize)
+
+	_, err := s.out.Write(header)
+	return err
+}
+
+func (s *Sound) Read(address uint16) byte {
+	switch {
+	case address == soundMaster:
+		return s.volume
+	case address < soundMaster:
+		return s.voices[address/7].regs[address%7]
+	}
+	return 0
+}
+
+func (s *Sound) Write(address uint16, value byte) {
+	s.render()
+
+	switch {
+	case address == soundMaster:
+		s.volume = value & 0x0F
+	case address < soundMaster:
+		v := &s.voices[address/7]
+		if address%7 == soundControl {
+			v.setGate(value&soundGate != 0, v.regs[soundControl]&soundGate != 0)
+		}
+		v.regs[address%7] = value
+	}
+}
+
+// render works out the samples due by now.
+func (s *Sound) render() {
+	due := uint64(float64(cpu.Cycles) * float64(s.Rate) / cpu.Frequency)
+	for ; s.samples < due; s.samples++ {
+		var mix float64
+		for i := range s.voices {
+			mix += s.voices[i].sample(s.Rate)
+		}
+		mix *= float64(s.volume) / 15 / float64(len(s.voices))
+
+		var buf [2]byte
+		binary.LittleEndian.PutUint16(buf[:], uint16(int16(math.Round(mix*math.MaxInt16))))
+		s.out.Write(buf[:])
+	}
+}
+
+func (v *soundVoice) setGate(on, was bool) {
+	switch {
+	case on && !was:
+		v.stage = soundAttack
+	case !on && was:
+		v.stage = soundRelease
+	}
+}
+
+func (v *soundVoice) sample(rate int) float64 {
+	frequency := float64(uint16(v.regs[soundFrequency])|uint16(v.regs[soundFrequency+1])<<8) *
+		cpu.Frequency / (1 << 24)
+	v.phase += frequency / float64(rate)
+	v.phase -= math.Floor(v.phase)
+
+	var wave float64
+	switch control := v.regs[soundControl]; {
+	case control&soundNoise != 0:
+		if step := int(v.phase * 8); step != v.noiseStep {
+			v.noiseStep = step
+			v.clockNoise()
+		}
+		wave = v.noiseBit
+	case control&soundSquare != 0:
+		wave = -1
+		if v.phase < float64(v.regs[soundPulse])/256 {
+			wave = 1
+		}
+	case control&soundSawtooth != 0:
+		wave = 2*v.phase - 1
+	case control&soundTriangle != 0:
+		wave = 1 - 4*math.Abs(v.phase-0.5)
+	}
+
+	v.envelope(rate)
+	return wave * v.level * float64(v.regs[soundVolume]&0x0F) / 15
+}
+
+// clockNoise steps the SID's 23 bit noise shift register.
+func (v *soundVoice) clockNoise() {
+	bit := (v.noise>>22 ^ v.noise>>17) & 0x01
+	v.noise = (v.noise<<1 | bit) & 0x7FFFFF
+	v.noiseBit = float64(v.noise&0x01)*2 - 1
+}
+
+// envelope moves the level in straight lines: up to full in the attack
+// time, down to the sustain level at the decay rate, and down to nothing
+// at the release rate, the rates being for the whole range.
+func (v *soundVoice) envelope(rate int) {
+	ad, sr := v.regs[soundAttackDecay], v.regs[soundSustainRelease]
+	sustain := float64(sr>>4) / 15
+
+	switch v.stage {
+	case soundAttack:
+		v.level += 1 / (soundAttackTimes[ad>>4] * float64(rate))
+		if v.level >= 1 {
+			v.level = 1
+			v.stage = soundDecay
+		}
+	case soundDecay:
+		if v.level > sustain {
+			v.level = math.Max(sustain, v.level-1/(3*soundAttackTimes[ad&0x0F]*float64(rate)))
+		}
+	case soundRelease:
+		v.level = math.Max(0, v.level-1/(3*soundAttackTimes[sr&0x0F]*float64(rate)))
+	}
+}
+
+// Close writes the samples up to the end of the run and the WAV sizes.
+func (s *Sound) Close() error {
+	s.render()
+	if err := s.out.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+
+	if _, err := s.f.Seek(0, 0); err != nil {
+		s.f.Close()
+		return err
+	}
+	s.out.Reset(s.f)
+	if err := s.writeHeader(uint32(s.samples * 2)); err != nil {
+		s.f.Close()
+		return err
+	}
+	if err := s.out.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
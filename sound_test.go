@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSoundWAV(t *testing.T) {
+	c := testCPU(t)
+	path := filepath.Join(t.TempDir(), "out.wav")
+	s, err := NewSound(path, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// voice 1: a 1kHz square wave, 2ms attack, full sustain, 6ms release
+	start := c.Cycles
+	s.Write(soundFrequency, 0x89)
+	s.Write(soundFrequency+1, 0x41)
+	s.Write(soundPulse, 0x80)
+	s.Write(soundAttackDecay, 0x00)
+	s.Write(soundSustainRelease, 0xF0)
+	s.Write(soundVolume, 15)
+	s.Write(soundControl, soundSquare|soundGate)
+	advance(c, 100000)
+	s.Write(soundControl, soundSquare)
+	advance(c, 100000)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wav, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := int((c.Cycles - start) * 10000 / 1e6)
+	le := binary.LittleEndian
+	switch {
+	case len(wav) != wavHeaderSize+2*samples:
+		t.Fatalf("%d bytes, want a header and %d samples", len(wav), samples)
+	case string(wav[0:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data":
+		t.Fatalf("bad header % X", wav[:wavHeaderSize])
+	case le.Uint32(wav[4:]) != uint32(len(wav)-8) || le.Uint32(wav[40:]) != uint32(2*samples):
+		t.Errorf("RIFF size %d, data size %d for %d bytes", le.Uint32(wav[4:]), le.Uint32(wav[40:]), len(wav))
+	case le.Uint32(wav[24:]) != 10000 || le.Uint16(wav[34:]) != 16:
+		t.Errorf("%d Hz, %d bits", le.Uint32(wav[24:]), le.Uint16(wav[34:]))
+	}
+
+	sample := func(i int) int16 {
+		return int16(le.Uint16(wav[wavHeaderSize+2*i:]))
+	}
+
+	// past the attack, one voice of three at full volume, changing sign
+	// twice a millisecond
+	changes := 0
+	for i := 100; i < 1000; i++ {
+		if v := sample(i); v != 10922 && v != -10922 {
+			t.Fatalf("sample %d is %d while sustained", i, v)
+		}
+		if sample(i) != sample(i-1) {
+			changes++
+		}
+	}
+	if changes < 178 || changes > 182 {
+		t.Errorf("%d sign changes in 90ms of 1kHz", changes)
+	}
+
+	// silent once released
+	for i := 1100; i < samples; i++ {
+		if v := sample(i); v != 0 {
+			t.Fatalf("sample %d is %d after the release", i, v)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols names addresses after the program's labels. They come from a
+// label file as ld65 -Ln writes it, lines like "al 008000 .Main", or
+// from an ld65 debug file, written with --dbgfile.
+type Symbols struct {
+	// addresses are the labelled addresses in order, names their labels
+	addresses []uint16
+	names     map[uint16]string
+}
+
+func LoadSymbols(path string) (*Symbols, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Symbols{names: make(map[uint16]string)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, debugLineMax)
+	for line := 1; scanner.Scan(); line++ {
+		var ok bool
+		if kind, fields, isDebug := parseDebugLine(scanner.Text()); isDebug {
+			ok = kind != "sym" || s.addDebugSymbol(fields)
+		} else {
+			ok = s.addLabel(scanner.Text())
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: bad symbol line", path, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for address := range s.names {
+		s.addresses = append(s.addresses, address)
+	}
+	sort.Slice(s.addresses, func(i, j int) bool { return s.addresses[i] < s.addresses[j] })
+	return s, nil
+}
+
+// addLabel reads a label file line. Local labels, starting with @, are
+// left out so addresses are named after the routine they are in.
+func (s *Symbols) addLabel(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	if len(fields) != 3 || fields[0] != "al" {
+		return false
+	}
+
+	address, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return false
+	}
+	s.add(uint16(address), strings.TrimPrefix(fields[2], "."))
+	return true
+}
+
+// addDebugSymbol reads the fields of a debug file sym line, keeping the
+// labels.
+func (s *Symbols) addDebugSymbol(fields map[string]string) bool {
+	if fields["type"] != "lab" {
+		return true
+	}
+
+	address, err := strconv.ParseUint(fields["val"], 0, 32)
+	if err != nil {
+		return false
+	}
+	s.add(uint16(address), fields["name"])
+	return true
+}
+
+func (s *Symbols) add(address uint16, name string) {
+	if _, ok := s.names[address]; ok || strings.HasPrefix(name, "@") {
+		return
+	}
+	s.names[address] = name
+}
+
+// Name returns the label at or before address, with the offset past it,
+// such as "Main+3", or "" with no label there.
+func (s *Symbols) Name(address uint16) string {
+	if s == nil {
+		return ""
+	}
+
+	i := sort.Search(len(s.addresses), func(i int) bool { return s.addresses[i] > address })
+	if i == 0 {
+		return ""
+	}
+	label := s.addresses[i-1]
+	if label == address {
+		return s.names[label]
+	}
+	return fmt.Sprintf("%s+%d", s.names[label], address-label)
+}
+
+// debugLineMax is the longest line read from a label or debug file. Debug
+// files put a whole scope or a long list of spans on one line.
+const debugLineMax = 1 << 20
+
+// parseDebugLine splits an ld65 debug file line, such as
+//
+//	sym	id=0,name="Main",addrsize=absolute,scope=0,def=5,val=0x8000,type=lab
+//
+// into its kind and fields, with quotes taken off. It reports false for
+// lines not in that form.
+func parseDebugLine(line string) (string, map[string]string, bool) {
+	kind, rest, ok := strings.Cut(line, "\t")
+	if !ok || kind == "" {
+		return "", nil, false
+	}
+
+	fields := make(map[string]string)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return "", nil, false
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return "", nil, false
+			}
+			fields[key] = value[1 : end+1]
+			rest = strings.TrimPrefix(value[end+2:], ",")
+			continue
+		}
+
+		value, rest, _ = strings.Cut(value, ",")
+		fields[key] = value
+	}
+	return kind, fields, true
+}
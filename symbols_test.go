@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadSymbols writes text to a symbol file and loads it.
+func loadSymbols(t *testing.T, text string) (*Symbols, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "program.sym")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadSymbols(path)
+}
+
+func TestSymbolsLabelFile(t *testing.T) {
+	s, err := loadSymbols(t, "al 008000 .Main\nal 008004 .@loop\n\nal 008010 .Print\nal 008010 .PrintAlias\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for address, want := range map[uint16]string{
+		0x7FFF: "",
+		0x8000: "Main",
+		0x8004: "Main+4",
+		0x8010: "Print",
+		0x8013: "Print+3",
+	} {
+		if got := s.Name(address); got != want {
+			t.Errorf("Name($%04X) = %q, want %q", address, got, want)
+		}
+	}
+
+	if _, err := loadSymbols(t, "al 008000 .Main\nMain = $8000\n"); err == nil || !strings.Contains(err.Error(), ":2: bad symbol line") {
+		t.Errorf("LoadSymbols returned %v for a bad line", err)
+	}
+}
+
+func TestSymbolsDebugFile(t *testing.T) {
+	// a scope line longer than the default 64 KB scanner buffer
+	scope := "scope\tid=0,name=\"\",mod=0,size=4096,span=" + strings.Repeat("1+", 40000) + "1\n"
+	s, err := loadSymbols(t, "version\tmajor=2,minor=0\n"+scope+
+		"sym\tid=0,name=\"Main\",addrsize=absolute,scope=0,def=5,val=0x8000,type=lab\n"+
+		"sym\tid=1,name=\"COUNT\",addrsize=zeropage,scope=0,def=6,val=0x10,type=equ\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Name(0x8002); got != "Main+2" {
+		t.Errorf("Name($8002) = %q, want Main+2", got)
+	}
+	if got := s.Name(0x0010); got != "" {
+		t.Errorf("Name($0010) = %q, want the equate left out", got)
+	}
+}
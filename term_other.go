@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+func rawInput(uintptr) (func(), error) {
+	return nil, errors.New("raw terminal input is not supported on this platform")
+}
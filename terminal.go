@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Terminal is a character display driven through registers, translated to
+// ANSI/VT100 escape sequences on the host terminal:
+//
+//	$00 write: print a character at the cursor
+//	$01 cursor column, from 0
+//	$02 cursor row, from 0
+//	$03 foreground color 0-15, $FF for the terminal default
+//	$04 background color 0-15, $FF for the terminal default
+//	$05 attributes: bit 0 bold, bit 1 underline, bit 2 reverse, bit 3 blink
+//	$06 write: command, see terminalClear and the rest
+//
+// Colors are the ANSI palette: black, red, green, yellow, blue, magenta,
+// cyan, white, then their bright versions.
+type Terminal struct {
+	Out io.Writer
+
+	x, y byte
+	// the cursor has been set but not moved there yet, so writing X and
+	// Y costs one escape sequence
+	moved bool
+	fg    byte
+	bg    byte
+	attrs byte
+}
+
+const (
+	terminalChar = iota
+	terminalX
+	terminalY
+	terminalFg
+	terminalBg
+	terminalAttrs
+	terminalCommand
+)
+
+const (
+	terminalClear = iota + 1
+	terminalClearLine
+	terminalScrollUp
+	terminalScrollDown
+	terminalHome
+	terminalHideCursor
+	terminalShowCursor
+	terminalReset
+)
+
+func NewTerminal() *Terminal {
+	return &Terminal{Out: os.Stdout, fg: 0xFF, bg: 0xFF}
+}
+
+func (t *Terminal) Read(address uint16) byte {
+	switch address {
+	case terminalX:
+		return t.x
+	case terminalY:
+		return t.y
+	case terminalFg:
+		return t.fg
+	case terminalBg:
+		return t.bg
+	case terminalAttrs:
+		return t.attrs
+	}
+	return 0
+}
+
+func (t *Terminal) Write(address uint16, value byte) {
+	switch address {
+	case terminalChar:
+		t.print(value)
+	case terminalX:
+		t.x = value
+		t.moved = true
+	case terminalY:
+		t.y = value
+		t.moved = true
+	case terminalFg:
+		t.fg = value
+		t.setGraphics()
+	case terminalBg:
+		t.bg = value
+		t.setGraphics()
+	case terminalAttrs:
+		t.attrs = value
+		t.setGraphics()
+	case terminalCommand:
+		t.command(value)
+	}
+}
+
+func (t *Terminal) print(value byte) {
+	if t.moved {
+		t.moved = false
+		fmt.Fprintf(t.Out, "\x1b[%d;%dH", int(t.y)+1, int(t.x)+1)
+	}
+
+	switch value {
+	case '\n':
+		t.x = 0
+		t.y++
+		fmt.Fprint(t.Out, "\n")
+	case '\r':
+		t.x = 0
+		fmt.Fprint(t.Out, "\r")
+	default:
+		t.x++
+		fmt.Fprintf(t.Out, "%c", rune(value))
+	}
+}
+
+func (t *Terminal) setGraphics() {
+	sgr := "\x1b[0"
+	if t.attrs&0x01 != 0 {
+		sgr += ";1"
+	}
+	if t.attrs&0x02 != 0 {
+		sgr += ";4"
+	}
+	if t.attrs&0x04 != 0 {
+		sgr += ";7"
+	}
+	if t.attrs&0x08 != 0 {
+		sgr += ";5"
+	}
+	if t.fg < 16 {
+		sgr += fmt.Sprintf(";%d", ansiColor(t.fg, 30))
+	}
+	if t.bg < 16 {
+		sgr += fmt.Sprintf(";%d", ansiColor(t.bg, 40))
+	}
+	fmt.Fprint(t.Out, sgr+"m")
+}
+
+// ansiColor is the SGR parameter for a color, base 30 for foreground and
+// 40 for background. Bright colors use the 90 and 100 ranges.
+func ansiColor(color byte, base int) int {
+	if color >= 8 {
+		return base + 60 + int(color-8)
+	}
+	return base + int(color)
+}
+
+func (t *Terminal) command(value byte) {
+	switch value {
+	case terminalClear:
+		t.x, t.y, t.moved = 0, 0, false
+		fmt.Fprint(t.Out, "\x1b[2J\x1b[H")
+	case terminalClearLine:
+		fmt.Fprint(t.Out, "\x1b[2K")
+	case terminalScrollUp:
+		fmt.Fprint(t.Out, "\x1b[S")
+	case terminalScrollDown:
+		fmt.Fprint(t.Out, "\x1b[T")
+	case terminalHome:
+		t.x, t.y, t.moved = 0, 0, false
+		fmt.Fprint(t.Out, "\x1b[H")
+	case terminalHideCursor:
+		fmt.Fprint(t.Out, "\x1b[?25l")
+	case terminalShowCursor:
+		fmt.Fprint(t.Out, "\x1b[?25h")
+	case terminalReset:
+		t.fg, t.bg, t.attrs = 0xFF, 0xFF, 0
+		fmt.Fprint(t.Out, "\x1b[0m")
+	}
+}
+
+// Close leaves the host terminal with default colors and a visible cursor
+// where the program last put it.
+func (t *Terminal) Close() error {
+	if t.moved {
+		fmt.Fprintf(t.Out, "\x1b[%d;%dH", int(t.y)+1, int(t.x)+1)
+	}
+	_, err := fmt.Fprint(t.Out, "\x1b[0m\x1b[?25h")
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"iter"
+)
+
+// BusCycle is a single access on the bus. Sync marks the first cycle of an
+// instruction (or of an interrupt or reset sequence), like the SYNC pin.
+type BusCycle struct {
+	Address uint16
+	Value   byte
+	Write   bool
+	Sync    bool
+}
+
+var errCoreStopped = errors.New("per-cycle core stopped")
+
+// The per-cycle core runs the ordinary instruction functions as a
+// coroutine. Each bus access parks the coroutine until Tick lets it happen,
+// so a Tick is exactly one clock and one bus access. Interrupts are polled
+// at the start of each cycle; an instruction sees the value polled for its
+// last cycle, which is what gives the 6502 its one instruction IRQ latency
+// after CLI.
+
+// Tick advances the CPU one clock cycle. Once the program has stopped it
+// returns why, as Step does.
+func (c *CPU) Tick() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if !c.PerCycle {
+		c.PerCycle = true
+		c.BusAccurate = true
+	}
+
+	if c.cycleNext == nil {
+		c.cycleNext, c.cycleStop = iter.Pull(c.runCycles)
+		if _, ok := c.cycleNext(); !ok {
+			return c.err
+		}
+	}
+
+	c.polled = c.interruptAsserted()
+	_, ok := c.cycleNext()
+	c.Scheduler.Run(c.Cycles)
+	if !ok {
+		return c.err
+	}
+	return nil
+}
+
+// Pending returns the bus access the next Tick will perform.
+func (c *CPU) Pending() BusCycle {
+	return c.pending
+}
+
+// stepCycles is Step for the per-cycle core: it ticks until the next
+// instruction is about to be fetched.
+func (c *CPU) stepCycles() error {
+	for {
+		if err := c.Tick(); err != nil {
+			return err
+		}
+
+		if c.pending.Sync {
+			return nil
+		}
+	}
+}
+
+func (c *CPU) runCycles(yield func(struct{}) bool) {
+	c.cycleYield = yield
+	defer func() {
+		c.cycleYield = nil
+		if r := recover(); r != nil && r != errCoreStopped {
+			panic(r)
+		}
+	}()
+
+	for {
+		if c.resetPending {
+			c.resetPending = false
+			c.reset()
+			continue
+		}
+
+		if c.polled {
+			c.interrupt()
+			continue
+		}
+
+		if c.execute() != nil {
+			return
+		}
+	}
+}
+
+// busCycle parks the per-cycle core until Tick lets this access happen.
+// Outside the core it does nothing.
+func (c *CPU) busCycle(address uint16, value byte, write bool) {
+	sync := c.sync
+	c.sync = false
+	if c.cycleYield == nil {
+		return
+	}
+
+	c.pending = BusCycle{Address: address, Value: value, Write: write, Sync: sync}
+	yield := c.cycleYield
+	c.cycleYield = nil
+	if !yield(struct{}{}) {
+		panic(errCoreStopped)
+	}
+	c.cycleYield = yield
+}
+
+func (c *CPU) stopCycles() {
+	if c.cycleStop != nil {
+		c.cycleStop()
+	}
+	c.cycleNext = nil
+	c.cycleStop = nil
+	c.polled = false
+}
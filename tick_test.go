@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+// tickProgram loops through a subroutine with stack, read-modify-write
+// and page-crossing instructions, then ends with NOP:
+//
+//	$8000       LDX #$00
+//	$8002 loop  TXA
+//	            STA $0300,X
+//	            JSR $8010
+//	            INX
+//	            CPX #$10
+//	            BNE loop
+//	            NOP
+//	$8010       ASL $0300,X
+//	            LDA $02F8,X
+//	            ADC #$01
+//	            PHA
+//	            PLA
+//	            RTS
+var tickProgram = []byte{
+	0xA2, 0x00, 0x8A, 0x9D, 0x00, 0x03, 0x20, 0x10, 0x80, 0xE8, 0xE0, 0x10, 0xD0, 0xF4, 0xEA, 0x00,
+	0x1E, 0x00, 0x03, 0xBD, 0xF8, 0x02, 0x69, 0x01, 0x48, 0x68, 0x60,
+}
+
+type tickState struct {
+	PC      uint16
+	A, X, Y byte
+	S, P    byte
+	Cycles  uint64
+}
+
+func runTickProgram(t *testing.T, perCycle bool) ([]tickState, []byte) {
+	c := testCPU(t, tickProgram...)
+	c.PerCycle = perCycle
+	var states []tickState
+	for {
+		err := c.Step()
+		if err == ErrHalted {
+			return states, append([]byte(nil), c.Ram[0x0300:0x0310]...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, tickState{c.PC, c.A, c.X, c.Y, c.S, c.P, c.Cycles})
+	}
+}
+
+func TestTickMatchesStep(t *testing.T) {
+	want, wantRAM := runTickProgram(t, false)
+	got, gotRAM := runTickProgram(t, true)
+
+	if len(got) != len(want) {
+		t.Fatalf("%d instructions per cycle, %d by Step", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("after instruction %d: per cycle %+v, Step %+v", i, got[i], want[i])
+		}
+	}
+	if string(gotRAM) != string(wantRAM) {
+		t.Errorf("memory per cycle % X, Step % X", gotRAM, wantRAM)
+	}
+}
+
+func TestTickIsOneCycle(t *testing.T) {
+	c := testCPU(t, tickProgram...)
+	start := c.Cycles
+	ticks := uint64(1)
+	for c.Tick() == nil {
+		ticks++
+	}
+	// the last tick fetched the NOP that halts
+	if cycles := c.Cycles - start; ticks != cycles {
+		t.Errorf("%d ticks took %d cycles", ticks, cycles)
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+// VIA is a 6522 Versatile Interface Adapter: two 8-bit ports with data
+// direction registers and CA/CB control lines, two 16-bit timers, a shift
+// register and the interrupt flag and enable registers.
+//
+// Timers are not stepped every cycle: their counters are worked out from
+// the cycle they were loaded at, and underflows are scheduler events.
+type VIA struct {
+	// InA and InB give the levels on the port pins the VIA does not drive,
+	// unconnected pins read high
+	InA func() byte
+	InB func() byte
+	// OutA and OutB are called with the port pins after the output or
+	// direction register changes, inputs float high
+	OutA func(pins byte)
+	OutB func(pins byte)
+	// CA2Out and CB2Out follow the control lines in output modes, CB2Out
+	// also gets the shift register's bits when shifting out
+	CA2Out func(level bool)
+	CB2Out func(level bool)
+	// CB2In is sampled when shifting in
+	CB2In func() bool
+
+	ora, orb   byte
+	ddra, ddrb byte
+	ira, irb   byte
+	acr, pcr   byte
+	ifr, ier   byte
+	irq        IRQLine
+
+	ca1, ca2, cb1, cb2 bool
+
+	t1Latch uint16
+	t1Base  uint64
+	t1Value uint16
+	t1Armed bool
+	t1Event *Event
+	pb7     bool
+
+	t2Latch byte
+	t2Base  uint64
+	t2Value uint16
+	t2Armed bool
+	t2Event *Event
+	t2Count uint16
+
+	sr      byte
+	srBits  int
+	srEvent *Event
+}
+
+const (
+	viaORB = iota
+	viaORA
+	viaDDRB
+	viaDDRA
+	viaT1CL
+	viaT1CH
+	viaT1LL
+	viaT1LH
+	viaT2CL
+	viaT2CH
+	viaSR
+	viaACR
+	viaPCR
+	viaIFR
+	viaIER
+	viaORANoHandshake
+)
+
+// interrupt flag bits
+const (
+	viaCA2 = 1 << iota
+	viaCA1
+	viaShift
+	viaCB2
+	viaCB1
+	viaTimer2
+	viaTimer1
+)
+
+func NewVIA() *VIA {
+	v := &VIA{irq: cpu.NewIRQLine()}
+	v.ca1, v.ca2, v.cb1, v.cb2 = true, true, true, true
+	return v
+}
+
+func (v *VIA) Read(address uint16) byte {
+	switch address & 0x0F {
+	case viaORB:
+		v.clearFlags(viaCB1 | v.independent(v.pcr>>5, viaCB2))
+		return v.readB()
+	case viaORA:
+		v.clearFlags(viaCA1 | v.independent(v.pcr>>1, viaCA2))
+		v.handshakeA()
+		return v.readA()
+	case viaDDRB:
+		return v.ddrb
+	case viaDDRA:
+		return v.ddra
+	case viaT1CL:
+		v.clearFlags(viaTimer1)
+		return byte(v.timer1())
+	case viaT1CH:
+		return byte(v.timer1() >> 8)
+	case viaT1LL:
+		return byte(v.t1Latch)
+	case viaT1LH:
+		return byte(v.t1Latch >> 8)
+	case viaT2CL:
+		v.clearFlags(viaTimer2)
+		return byte(v.timer2())
+	case viaT2CH:
+		return byte(v.timer2() >> 8)
+	case viaSR:
+		v.clearFlags(viaShift)
+		v.startShift()
+		return v.sr
+	case viaACR:
+		return v.acr
+	case viaPCR:
+		return v.pcr
+	case viaIFR:
+		if v.ifr&v.ier&0x7F != 0 {
+			return v.ifr | 0x80
+		}
+		return v.ifr
+	case viaIER:
+		return v.ier | 0x80
+	default:
+		return v.readA()
+	}
+}
+
+func (v *VIA) Write(address uint16, value byte) {
+	switch address & 0x0F {
+	case viaORB:
+		v.orb = value
+		v.clearFlags(viaCB1 | v.independent(v.pcr>>5, viaCB2))
+		v.outputB()
+		v.handshakeB()
+	case viaORA:
+		v.ora = value
+		v.clearFlags(viaCA1 | v.independent(v.pcr>>1, viaCA2))
+		v.outputA()
+		v.handshakeA()
+	case viaDDRB:
+		v.ddrb = value
+		v.outputB()
+	case viaDDRA:
+		v.ddra = value
+		v.outputA()
+	case viaT1CL, viaT1LL:
+		v.t1Latch = v.t1Latch&0xFF00 | uint16(value)
+	case viaT1CH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(value)<<8
+		v.clearFlags(viaTimer1)
+		v.loadTimer1(cpu.Cycles, v.t1Latch)
+		v.t1Armed = true
+		if v.acr&0x80 != 0 {
+			v.pb7 = false
+			v.outputB()
+		}
+	case viaT1LH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(value)<<8
+		v.clearFlags(viaTimer1)
+	case viaT2CL:
+		v.t2Latch = value
+	case viaT2CH:
+		v.clearFlags(viaTimer2)
+		v.loadTimer2(uint16(value)<<8 | uint16(v.t2Latch))
+	case viaSR:
+		v.sr = value
+		v.clearFlags(viaShift)
+		v.startShift()
+	case viaACR:
+		v.acr = value
+		v.startShift()
+		v.outputB()
+	case viaPCR:
+		v.pcr = value
+		v.controlOutputs()
+	case viaIFR:
+		v.clearFlags(value & 0x7F)
+	case viaIER:
+		if value&0x80 != 0 {
+			v.ier |= value & 0x7F
+		} else {
+			v.ier &^= value
+		}
+		v.updateIRQ()
+	default:
+		v.ora = value
+		v.outputA()
+	}
+}
+
+func (v *VIA) setFlags(flags byte) {
+	v.ifr |= flags
+	v.updateIRQ()
+}
+
+func (v *VIA) clearFlags(flags byte) {
+	v.ifr &^= flags
+	v.updateIRQ()
+}
+
+func (v *VIA) updateIRQ() {
+	cpu.SetIRQ(v.irq, v.ifr&v.ier&0x7F != 0)
+}
+
+// independent returns the flag for a CA2/CB2 input in independent mode,
+// where port accesses do not clear it, and 0 otherwise.
+func (v *VIA) independent(control byte, flag byte) byte {
+	if control&0x05 == 0x01 {
+		return 0
+	}
+	return flag
+}
+
+func (v *VIA) readA() byte {
+	in := v.pinsIn(v.InA)
+	if v.acr&0x01 != 0 {
+		in = v.ira
+	}
+	return v.ora&v.ddra | in&^v.ddra
+}
+
+func (v *VIA) readB() byte {
+	in := v.pinsIn(v.InB)
+	if v.acr&0x02 != 0 {
+		in = v.irb
+	}
+	value := v.orb&v.ddrb | in&^v.ddrb
+	if v.acr&0x80 != 0 {
+		value &^= 0x80
+		if v.pb7 {
+			value |= 0x80
+		}
+	}
+	return value
+}
+
+func (v *VIA) pinsIn(in func() byte) byte {
+	if in == nil {
+		return 0xFF
+	}
+	return in()
+}
+
+func (v *VIA) outputA() {
+	if v.OutA != nil {
+		v.OutA(v.ora&v.ddra | ^v.ddra)
+	}
+}
+
+func (v *VIA) outputB() {
+	if v.OutB == nil {
+		return
+	}
+
+	pins := v.orb&v.ddrb | ^v.ddrb
+	if v.acr&0x80 != 0 {
+		pins &^= 0x80
+		if v.pb7 {
+			pins |= 0x80
+		}
+	}
+	v.OutB(pins)
+}
+
+// Control lines
+
+func (v *VIA) SetCA1(level bool) {
+	if level != v.ca1 && level == (v.pcr&0x01 != 0) {
+		v.ira = v.pinsIn(v.InA)
+		v.setFlags(viaCA1)
+		if v.pcr&0x0E == 0x08 {
+			v.setCA2(true)
+		}
+	}
+	v.ca1 = level
+}
+
+func (v *VIA) SetCA2(level bool) {
+	if v.pcr&0x08 == 0 && level != v.ca2 && level == (v.pcr&0x04 != 0) {
+		v.setFlags(viaCA2)
+	}
+	v.ca2 = level
+}
+
+func (v *VIA) SetCB1(level bool) {
+	if level != v.cb1 && level == (v.pcr&0x10 != 0) {
+		v.irb = v.pinsIn(v.InB)
+		v.setFlags(viaCB1)
+		if v.pcr&0xE0 == 0x80 {
+			v.setCB2(true)
+		}
+	}
+
+	if v.cb1 && !level && v.srMode()&0x03 == 0x03 {
+		v.shiftBit(cpu.Cycles)
+	}
+	v.cb1 = level
+}
+
+func (v *VIA) SetCB2(level bool) {
+	if v.pcr&0x80 == 0 && level != v.cb2 && level == (v.pcr&0x40 != 0) {
+		v.setFlags(viaCB2)
+	}
+	v.cb2 = level
+}
+
+func (v *VIA) setCA2(level bool) {
+	v.ca2 = level
+	if v.CA2Out != nil {
+		v.CA2Out(level)
+	}
+}
+
+func (v *VIA) setCB2(level bool) {
+	v.cb2 = level
+	if v.CB2Out != nil {
+		v.CB2Out(level)
+	}
+}
+
+// controlOutputs drives CA2 and CB2 when the PCR puts them in manual
+// output mode.
+func (v *VIA) controlOutputs() {
+	switch v.pcr & 0x0E {
+	case 0x0C:
+		v.setCA2(false)
+	case 0x0E:
+		v.setCA2(true)
+	}
+
+	switch v.pcr & 0xE0 {
+	case 0xC0:
+		v.setCB2(false)
+	case 0xE0:
+		v.setCB2(true)
+	}
+}
+
+// handshakeA drops CA2 on port A access in handshake and pulse modes. In
+// handshake mode CA1 raises it again, a pulse lasts one cycle.
+func (v *VIA) handshakeA() {
+	switch v.pcr & 0x0E {
+	case 0x08:
+		v.setCA2(false)
+	case 0x0A:
+		v.setCA2(false)
+		cpu.After(1, func(uint64) { v.setCA2(true) })
+	}
+}
+
+// handshakeB is the CB2 counterpart, triggered by writes to port B only.
+func (v *VIA) handshakeB() {
+	switch v.pcr & 0xE0 {
+	case 0x80:
+		v.setCB2(false)
+	case 0xA0:
+		v.setCB2(false)
+		cpu.After(1, func(uint64) { v.setCB2(true) })
+	}
+}
+
+// Timer 1
+
+// loadTimer1 starts T1 counting down from value the cycle after base. It
+// reaches 0 after value cycles and underflows through $FFFF one cycle
+// later, which is when the interrupt flag is set. In free-running mode
+// the latch is reloaded on the next cycle, making the period value+2.
+func (v *VIA) loadTimer1(base uint64, value uint16) {
+	v.t1Base = base
+	v.t1Value = value
+	cpu.Scheduler.Cancel(v.t1Event)
+	v.t1Event = cpu.Scheduler.At(base+uint64(value)+2, v.timer1Underflow)
+}
+
+func (v *VIA) timer1() uint16 {
+	if cpu.Cycles <= v.t1Base {
+		return v.t1Value
+	}
+
+	return v.t1Value - uint16(cpu.Cycles-v.t1Base-1)
+}
+
+func (v *VIA) timer1Underflow(cycle uint64) {
+	v.t1Event = nil
+	freeRunning := v.acr&0x40 != 0
+
+	if v.t1Armed {
+		v.setFlags(viaTimer1)
+		if v.acr&0x80 != 0 {
+			v.pb7 = !v.pb7 || !freeRunning
+			v.outputB()
+		}
+	}
+
+	if freeRunning {
+		v.loadTimer1(cycle, v.t1Latch)
+		return
+	}
+
+	// one-shot: the counter rolls on through $FFFF without interrupting
+	v.t1Armed = false
+}
+
+// Timer 2
+
+func (v *VIA) loadTimer2(value uint16) {
+	v.t2Armed = true
+	cpu.Scheduler.Cancel(v.t2Event)
+	v.t2Event = nil
+
+	if v.acr&0x20 != 0 {
+		v.t2Count = value
+		return
+	}
+
+	v.t2Base = cpu.Cycles
+	v.t2Value = value
+	v.t2Event = cpu.After(uint64(value)+2, v.timer2Underflow)
+}
+
+func (v *VIA) timer2() uint16 {
+	if v.acr&0x20 != 0 {
+		return v.t2Count
+	}
+
+	if cpu.Cycles <= v.t2Base {
+		return v.t2Value
+	}
+
+	return v.t2Value - uint16(cpu.Cycles-v.t2Base-1)
+}
+
+func (v *VIA) timer2Underflow(cycle uint64) {
+	v.t2Event = nil
+	if v.t2Armed {
+		v.setFlags(viaTimer2)
+		v.t2Armed = false
+	}
+}
+
+// PulsePB6 counts a falling edge on PB6 when T2 is in pulse counting mode.
+func (v *VIA) PulsePB6() {
+	if v.acr&0x20 == 0 {
+		return
+	}
+
+	v.t2Count--
+	if v.t2Count == 0xFFFF && v.t2Armed {
+		v.setFlags(viaTimer2)
+		v.t2Armed = false
+	}
+}
+
+// Shift register
+
+func (v *VIA) srMode() byte {
+	return v.acr >> 2 & 0x07
+}
+
+// startShift begins shifting 8 bits after the shift register is accessed
+// or its mode changes. Bits under T2 control take two T2 low-latch
+// timeouts each, under φ2 two cycles each, under CB1 they wait for the
+// external clock.
+func (v *VIA) startShift() {
+	cpu.Scheduler.Cancel(v.srEvent)
+	v.srEvent = nil
+
+	mode := v.srMode()
+	if mode == 0 {
+		return
+	}
+
+	v.srBits = 8
+	if mode&0x03 != 0x03 {
+		v.srEvent = cpu.After(v.shiftPeriod(), v.shiftBit)
+	}
+}
+
+func (v *VIA) shiftPeriod() uint64 {
+	if v.srMode()&0x03 == 0x02 {
+		return 2
+	}
+	return 2 * (uint64(v.t2Latch) + 2)
+}
+
+func (v *VIA) shiftBit(cycle uint64) {
+	v.srEvent = nil
+	mode := v.srMode()
+	if mode == 0 || v.srBits == 0 {
+		return
+	}
+
+	if mode&0x04 != 0 {
+		out := v.sr&0x80 != 0
+		v.sr = v.sr<<1 | v.sr>>7
+		v.setCB2(out)
+	} else {
+		v.sr <<= 1
+		if v.CB2In == nil || v.CB2In() {
+			v.sr |= 0x01
+		}
+	}
+
+	v.srBits--
+	if v.srBits == 0 {
+		// free-running shift out goes on forever without interrupting
+		if mode == 4 {
+			v.srBits = 8
+		} else {
+			v.setFlags(viaShift)
+			return
+		}
+	}
+
+	if mode&0x03 != 0x03 {
+		v.srEvent = cpu.Scheduler.At(cycle+v.shiftPeriod(), v.shiftBit)
+	}
+}
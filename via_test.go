@@ -0,0 +1,115 @@
+package main
+
+import "testing"
+
+func TestVIATimer1OneShot(t *testing.T) {
+	c := testCPU(t)
+	v := NewVIA()
+	base := c.Cycles
+	v.Write(viaT1CL, 10)
+	v.Write(viaT1CH, 0)
+
+	advance(c, 5)
+	if got := uint16(v.Read(viaT1LH))<<8 | uint16(v.Read(viaT1LL)); got != 10 {
+		t.Fatalf("latch = %d, want 10", got)
+	}
+	if got := v.timer1(); got != 6 {
+		t.Errorf("T1 = %d 5 cycles after loading 10, want 6", got)
+	}
+
+	// the flag is set as the counter goes through $FFFF, value+2 cycles on
+	advance(c, base+11-c.Cycles)
+	if v.ifr&viaTimer1 != 0 {
+		t.Fatal("T1 underflowed after value+1 cycles")
+	}
+	advance(c, 1)
+	if v.ifr&viaTimer1 == 0 {
+		t.Fatal("T1 did not underflow after value+2 cycles")
+	}
+	if c.irq != 0 {
+		t.Error("IRQ with T1 interrupts disabled")
+	}
+
+	v.Write(viaIER, 0x80|viaTimer1)
+	if c.irq == 0 || v.Read(viaIFR) != 0x80|viaTimer1 {
+		t.Error("no IRQ after enabling T1 interrupts")
+	}
+	v.Read(viaT1CL)
+	if c.irq != 0 || v.ifr != 0 {
+		t.Error("reading T1CL did not clear the interrupt")
+	}
+
+	// one-shot: rolling through $FFFF again does not interrupt
+	advance(c, 0x10000)
+	if v.ifr != 0 {
+		t.Error("T1 interrupted twice in one-shot mode")
+	}
+}
+
+func TestVIATimer1FreeRunning(t *testing.T) {
+	c := testCPU(t)
+	v := NewVIA()
+	v.Write(viaACR, 0x40)
+	base := c.Cycles
+	v.Write(viaT1CL, 10)
+	v.Write(viaT1CH, 0)
+
+	for _, at := range []uint64{12, 24, 36} {
+		advance(c, base+at-1-c.Cycles)
+		if v.ifr&viaTimer1 != 0 {
+			t.Fatalf("T1 underflowed before cycle %d", at)
+		}
+		advance(c, 1)
+		if v.ifr&viaTimer1 == 0 {
+			t.Fatalf("T1 did not underflow at cycle %d", at)
+		}
+		v.Read(viaT1CL)
+	}
+}
+
+func TestVIATimer2(t *testing.T) {
+	c := testCPU(t)
+	v := NewVIA()
+	v.Write(viaIER, 0x80|viaTimer2)
+	v.Write(viaT2CL, 5)
+	v.Write(viaT2CH, 0)
+
+	advance(c, 6)
+	if c.irq != 0 {
+		t.Fatal("T2 underflowed after value+1 cycles")
+	}
+	advance(c, 1)
+	if c.irq == 0 {
+		t.Fatal("no IRQ from T2 after value+2 cycles")
+	}
+	v.Read(viaT2CL)
+	advance(c, 0x10000)
+	if c.irq != 0 {
+		t.Error("T2 interrupted twice")
+	}
+}
+
+func TestVIAInterruptsCPU(t *testing.T) {
+	// CLI, JMP $8001; the handler at $8100 is LDA $6004, INX, RTI
+	c := testCPU(t, 0x58, 0x4C, 0x01, 0x80)
+	copy(c.Rom[0x100:], []byte{0xAD, 0x04, 0x60, 0xE8, 0x40})
+	c.Rom[0x7FFE], c.Rom[0x7FFF] = 0x00, 0x81
+
+	v := NewVIA()
+	if err := c.Bus.Attach(0x6000, 16, v); err != nil {
+		t.Fatal(err)
+	}
+	v.Write(viaACR, 0x40)
+	v.Write(viaIER, 0x80|viaTimer1)
+	base := c.Cycles
+	// a period of 1000 cycles
+	v.Write(viaT1CL, 0xE6)
+	v.Write(viaT1CH, 0x03)
+
+	if err := c.Run(func() bool { return c.Cycles >= base+9500 }); err != nil {
+		t.Fatal(err)
+	}
+	if c.X != 9 {
+		t.Errorf("%d interrupts handled in 9 periods", c.X)
+	}
+}
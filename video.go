@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TextVideo is a memory-mapped text screen drawn on the host terminal. The
+// program writes screen codes and colors straight into video memory, and
+// on every vertical blank the cells that changed are redrawn:
+//
+//	$0000 characters, one byte per cell, row by row. Codes $20-$7E are
+//	      ASCII, bit 7 shows the character in reverse
+//	page  colors, one byte per cell: foreground in the low nibble,
+//	      background in the high one, ANSI palette as in Terminal
+//	regs  +0 status: bit 7 vblank happened, cleared by reading
+//	      +1 control: bit 0 IRQ on vblank
+//
+// The color page starts at the first 256 byte boundary after the
+// characters and the registers after the colors, so for 40x25 they are
+// at $0400 and $0800.
+type TextVideo struct {
+	Out     *bufio.Writer
+	Columns int
+	Rows    int
+	// Rate is the refresh rate in Hz of emulated time
+	Rate float64
+
+	page    uint16
+	memory  []byte
+	shown   []byte
+	drawn   bool
+	status  byte
+	control byte
+	irq     IRQLine
+
+	lastDraw time.Time
+}
+
+func NewTextVideo(columns, rows int, rate float64) (*TextVideo, error) {
+	cells := columns * rows
+	if cells <= 0 || 2*((cells+0xFF)&^0xFF)+2 > 0x10000 {
+		return nil, fmt.Errorf("a %dx%d text screen does not fit in memory", columns, rows)
+	}
+
+	page := uint16((cells + 0xFF) &^ 0xFF)
+	v := &TextVideo{
+		Out:     bufio.NewWriter(os.Stdout),
+		Columns: columns,
+		Rows:    rows,
+		Rate:    rate,
+		page:    page,
+		memory:  make([]byte, 2*int(page)),
+		shown:   make([]byte, 2*int(page)),
+		irq:     cpu.NewIRQLine(),
+	}
+
+	for i := 0; i < cells; i++ {
+		v.memory[i] = ' '
+		v.memory[int(page)+i] = 0x07
+	}
+	cpu.After(v.frameCycles(), v.vblank)
+	return v, nil
+}
+
+// Size is the address space the screen takes on the bus.
+func (v *TextVideo) Size() int {
+	return 2*int(v.page) + 2
+}
+
+func (v *TextVideo) Read(address uint16) byte {
+	if int(address) < len(v.memory) {
+		return v.memory[address]
+	}
+
+	if address == 2*v.page {
+		status := v.status
+		v.status = 0
+		cpu.SetIRQ(v.irq, false)
+		return status
+	}
+	return v.control
+}
+
+func (v *TextVideo) Write(address uint16, value byte) {
+	if int(address) < len(v.memory) {
+		v.memory[address] = value
+		return
+	}
+
+	if address == 2*v.page+1 {
+		v.control = value
+		cpu.SetIRQ(v.irq, v.control&0x01 != 0 && v.status != 0)
+	}
+}
+
+func (v *TextVideo) frameCycles() uint64 {
+	return uint64(cpu.Frequency/v.Rate) + 1
+}
+
+func (v *TextVideo) vblank(cycle uint64) {
+	cpu.Scheduler.At(cycle+v.frameCycles(), v.vblank)
+
+	v.status = 0x80
+	cpu.SetIRQ(v.irq, v.control&0x01 != 0)
+
+	// an unthrottled CPU goes through frames far faster than the terminal
+	// can show them
+	if time.Since(v.lastDraw).Seconds() >= 1/v.Rate {
+		v.draw()
+	}
+}
+
+// draw sends the cells that changed since the last frame.
+func (v *TextVideo) draw() {
+	v.lastDraw = time.Now()
+	if !v.drawn {
+		fmt.Fprint(v.Out, "\x1b[2J\x1b[?25l")
+	}
+
+	cursor := -1
+	color := -1
+	for i := 0; i < v.Columns*v.Rows; i++ {
+		char, attr := v.memory[i], v.memory[int(v.page)+i]
+		if v.drawn && char == v.shown[i] && attr == v.shown[int(v.page)+i] {
+			continue
+		}
+		v.shown[i], v.shown[int(v.page)+i] = char, attr
+
+		if cursor != i {
+			fmt.Fprintf(v.Out, "\x1b[%d;%dH", i/v.Columns+1, i%v.Columns+1)
+		}
+		if int(attr)|int(char&0x80)<<1 != color {
+			color = int(attr) | int(char&0x80)<<1
+			v.setColor(attr, char&0x80 != 0)
+		}
+
+		char &= 0x7F
+		if char < 0x20 || char == 0x7F {
+			char = ' '
+		}
+		v.Out.WriteByte(char)
+		cursor = i + 1
+		if cursor%v.Columns == 0 {
+			cursor = -1
+		}
+	}
+
+	v.drawn = true
+	v.Out.Flush()
+}
+
+func (v *TextVideo) setColor(attr byte, reverse bool) {
+	sgr := fmt.Sprintf("\x1b[0;%d;%d", ansiColor(attr&0x0F, 30), ansiColor(attr>>4, 40))
+	if reverse {
+		sgr += ";7"
+	}
+	fmt.Fprint(v.Out, sgr+"m")
+}
+
+// Close draws the final frame and leaves the cursor below the screen.
+func (v *TextVideo) Close() error {
+	v.draw()
+	fmt.Fprintf(v.Out, "\x1b[0m\x1b[%d;1H\x1b[?25h", v.Rows+1)
+	return v.Out.Flush()
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestTextVideoSize(t *testing.T) {
+	testCPU(t)
+	v, err := NewTextVideo(40, 25, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// characters and colors a page apart each, and two registers
+	if v.Size() != 2*0x400+2 {
+		t.Errorf("40x25 screen takes $%X bytes", v.Size())
+	}
+
+	for _, size := range [][2]int{{256, 128}, {200, 200}, {0, 25}, {40, -1}} {
+		if _, err := NewTextVideo(size[0], size[1], 60); err == nil {
+			t.Errorf("a %dx%d screen was made", size[0], size[1])
+		}
+	}
+}